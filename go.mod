@@ -1,9 +1,8 @@
 module github.com/unsecured-company/nsec3walker
 
-go 1.23.2
+go 1.25
 
 require (
-	github.com/emirpasic/gods v1.18.1
 	github.com/miekg/dns v1.1.66
 	github.com/spf13/cobra v1.9.1
 	golang.org/x/net v0.41.0
@@ -0,0 +1,225 @@
+package nsec3walker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	Concurrency       = 4
+	ZoneStatusDone    = "complete"
+	ZoneStatusError   = "error"
+	ZoneStatusNoNs    = "no NS left"
+	ZoneStatusStalled = "stalled"
+	ZoneStatusStopped = "interrupted"
+	ZoneStatusSkipped = "skipped"
+)
+
+// ZoneResult is a line of the final summary of a batch walk.
+type ZoneResult struct {
+	Domain   string
+	Status   string
+	Hashes   int64
+	Queries  int64
+	Prefix   string // output files of the chain the walk ended on, they differ from the zone's after a rollover
+	Duration time.Duration
+	Err      error
+}
+
+// RunWalkBatch walks zones from the domains file, at most Concurrency of them at once.
+// Every zone has its own walker, so its own ranges, stats, NSEC3 params and output files.
+func (nw *NSec3Walker) RunWalkBatch(ctx context.Context) (err error) {
+	domains, err := readDomainsFile(nw.config.DomainsFile)
+
+	if err != nil {
+		return
+	}
+
+	nw.out.Logf("Walking %d zones from %s, %d at once", len(domains), nw.config.DomainsFile, nw.config.Concurrency)
+
+	results := make([]ZoneResult, len(domains))
+	semaphore := make(chan struct{}, nw.config.Concurrency)
+	wg := sync.WaitGroup{}
+
+	for i, domain := range domains {
+		select {
+		case <-ctx.Done():
+		case semaphore <- struct{}{}:
+		}
+
+		if ctx.Err() != nil {
+			results[i] = ZoneResult{Domain: domain, Status: ZoneStatusSkipped}
+
+			continue
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			results[i] = nw.walkZone(ctx, domain)
+		}()
+	}
+
+	wg.Wait()
+	nw.logBatchSummary(results)
+
+	return
+}
+
+func (nw *NSec3Walker) walkZone(ctx context.Context, domain string) (result ZoneResult) {
+	result = ZoneResult{Domain: domain}
+	timeStart := time.Now()
+
+	config := nw.config.forZone(domain)
+	err := config.setZoneOutput(nw.config.filePathPrefix)
+
+	if err != nil {
+		result.Status = ZoneStatusError
+		result.Err = err
+
+		return
+	}
+
+	defer config.Output.Close()
+
+	zone := NewNSec3Walker(config)
+	result = zone.result(zone.RunWalk(ctx))
+	result.Duration = time.Since(timeStart).Round(time.Second)
+
+	if result.Err != nil {
+		config.Output.Log(result.Err.Error())
+	}
+
+	return
+}
+
+// result describes the ended walk. Queries are counted across rollovers, hashes and the output files
+// are of the chain the walk ended on, which is the complete one.
+func (nw *NSec3Walker) result(err error) ZoneResult {
+	prefix := nw.config.filePathPrefix
+
+	if nw.chain != nil {
+		prefix = nw.chain.prefix
+	}
+
+	return ZoneResult{
+		Domain:  nw.config.Domain,
+		Status:  nw.status(err),
+		Hashes:  nw.stats.hashes.Load(),
+		Queries: nw.queriesRolled + nw.stats.queries.Load(),
+		Prefix:  prefix,
+		Err:     err,
+	}
+}
+
+// status describes how the walk ended, from the error of RunWalk and the stop cause.
+func (nw *NSec3Walker) status(err error) string {
+	switch {
+	case err != nil:
+		return ZoneStatusError
+	case errors.Is(nw.stopCause, errWalkFinished):
+		return ZoneStatusDone
+	case errors.Is(nw.stopCause, errNoNewHashes):
+		return ZoneStatusStalled
+	case errors.Is(nw.stopCause, context.Canceled):
+		return ZoneStatusStopped
+	default:
+		return ZoneStatusNoNs
+	}
+}
+
+func (nw *NSec3Walker) logBatchSummary(results []ZoneResult) {
+	nw.out.Log(fmt.Sprintf("%-30s %-12s %10s %10s %10s", "Zone", "Status", "Hashes", "Queries", "Duration"))
+
+	cntDone := 0
+
+	for _, result := range results {
+		line := fmt.Sprintf("%-30s %-12s %10d %10d %10v", result.Domain, result.Status, result.Hashes, result.Queries, result.Duration)
+
+		if result.Err != nil {
+			line += " | " + result.Err.Error()
+		}
+
+		if result.Status == ZoneStatusDone {
+			cntDone++
+		}
+
+		nw.out.Log(line)
+	}
+
+	nw.out.Logf("Completed %d of %d zones", cntDone, len(results))
+}
+
+// forZone copies the config for a single zone walked next to others, with its own output.
+func (cnf *Config) forZone(domain string) (config *Config) {
+	zone := *cnf
+	config = &zone
+	config.Domain = domain
+	config.DomainsFile = ""
+	config.Action = ActionWalk
+	config.Output = cnf.Output.NewChild(domain)
+
+	return
+}
+
+// setZoneOutput sets output files of the zone into the directory, nothing is set for an empty directory.
+func (cnf *Config) setZoneOutput(directory string) (err error) {
+	if directory == "" {
+		return
+	}
+
+	cnf.filePathPrefix, err = GetOutputFilePrefix(directory, cnf.Domain)
+
+	if err == nil {
+		err = cnf.Output.SetFilePrefix(cnf.filePathPrefix)
+	}
+
+	if err == nil {
+		cnf.Output.Log("Logging into " + cnf.filePathPrefix + ".[log,csv,hash,changes]")
+	}
+
+	return
+}
+
+// readDomainsFile returns unique domains, one per line. Empty lines and # comments are skipped.
+func readDomainsFile(filePath string) (domains []string, err error) {
+	file, err := os.Open(filePath)
+
+	if err != nil {
+		return
+	}
+
+	defer file.Close()
+
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		domain := strings.TrimSpace(scanner.Text())
+		domain = strings.ToLower(strings.Trim(domain, "."))
+
+		if domain == "" || strings.HasPrefix(domain, "#") || seen[domain] {
+			continue
+		}
+
+		seen[domain] = true
+		domains = append(domains, domain)
+	}
+
+	err = scanner.Err()
+
+	if err == nil && len(domains) == 0 {
+		err = fmt.Errorf("no domains in %s", filePath)
+	}
+
+	return
+}
@@ -0,0 +1,223 @@
+package nsec3walker
+
+import (
+	"encoding/binary"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	BenchmarkDomain   = "example.com"
+	BenchmarkSalt     = "aabbccddeeff0011"
+	BenchmarkDuration = 3 * time.Second
+	cntBenchmarkWords = 4096
+	cntBenchmarkHits  = 1_000_000
+)
+
+var (
+	BenchmarkIterations = []int{0, 10, 100, 2500}
+	BenchmarkRanges     = []int{1_000_000, 10_000_000, 100_000_000}
+)
+
+// runBenchmark measures hashes per second of the cracker on all CPUs, for the usual iteration counts.
+// Domain and salt can be given by flags, the zone suffix length changes the speed a little.
+func (c *Cracking) runBenchmark() (err error) {
+	domain := c.cnf.Domain
+	salt := c.cnf.Salt
+
+	if domain == "" {
+		domain = BenchmarkDomain
+	}
+
+	if salt == "" {
+		salt = BenchmarkSalt
+	}
+
+	words := make([]string, cntBenchmarkWords)
+
+	for i := range words {
+		words[i] = "w" + strconv.Itoa(i)
+	}
+
+	threads := runtime.NumCPU()
+	c.out.Logf("Benchmarking NSEC3 hashing of [%s] with salt [%s], %d threads, %v each", domain, salt, threads, BenchmarkDuration)
+
+	for _, iterations := range BenchmarkIterations {
+		n3p, err := NewNsec3Params(domain, salt, iterations)
+
+		if err != nil {
+			return err
+		}
+
+		cnt, elapsed, err := benchmarkHashes(n3p, words, threads)
+
+		if err != nil {
+			return err
+		}
+
+		perSec := float64(cnt) / elapsed.Seconds()
+		c.out.Logf("Iterations %4d: %14.0f hashes/s, %12.0f per thread", iterations, perSec, perSec/float64(threads))
+	}
+
+	return
+}
+
+func benchmarkHashes(n3p Nsec3Params, words []string, threads int) (cnt int64, elapsed time.Duration, err error) {
+	var total atomic.Int64
+	var wg sync.WaitGroup
+	var stop atomic.Bool
+
+	hashers := make([]*Nsec3Hasher, threads)
+
+	for i := range hashers {
+		hashers[i], err = n3p.NewHasher()
+
+		if err != nil {
+			return
+		}
+	}
+
+	timeStart := time.Now()
+
+	for _, hasher := range hashers {
+		wg.Go(func() {
+			var n int64
+
+			for i := 0; !stop.Load(); i++ {
+				_, _ = hasher.DigestPrefix(words[i%len(words)])
+				n++
+			}
+
+			total.Add(n)
+		})
+	}
+
+	time.Sleep(BenchmarkDuration)
+	stop.Store(true)
+	wg.Wait()
+
+	return total.Load(), time.Since(timeStart), nil
+}
+
+// RunIndexBenchmark fills the range index with a complete chain of every size, the ranges come scattered
+// over the hash space as from a walk. Speed of adding and lookups, memory per range and time of
+// the checks done while walking are logged. With --index-dir the disk index is measured, and its file size.
+func (nw *NSec3Walker) RunIndexBenchmark() (err error) {
+	for _, cnt := range nw.config.benchmarkRanges {
+		err = nw.benchmarkIndex(cnt)
+
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (nw *NSec3Walker) benchmarkIndex(cnt int) (err error) {
+	var memBefore, memAfter runtime.MemStats
+	var timeGaps time.Duration
+
+	runtime.GC()
+	runtime.ReadMemStats(&memBefore)
+
+	ranges := NewRangeIndex()
+	path := filepath.Join(nw.config.IndexDir, "benchmark"+SuffixIndex)
+
+	if nw.config.IndexDir != "" {
+		ranges, _, err = OpenRangeIndex(path, "benchmark", false)
+
+		if err != nil {
+			return
+		}
+
+		defer os.Remove(path)
+	}
+	step := ^uint64(0) / uint64(cnt) // hashes are spread evenly over the hash space
+	stride := benchmarkStride(cnt)
+	timeStart := time.Now()
+
+	for i, pos := 0, 0; i < cnt; i++ {
+		pos = (pos + stride) % cnt
+		_, _, _, _ = ranges.Add(benchmarkHash(pos, step), benchmarkHash((pos+1)%cnt, step))
+
+		if i == cnt/2 {
+			timeGapsStart := time.Now()
+			ranges.Gaps(GapTargets)
+			timeGaps = time.Since(timeGapsStart)
+			timeStart = timeStart.Add(timeGaps)
+		}
+	}
+
+	timeAdd := time.Since(timeStart)
+
+	runtime.GC()
+	runtime.ReadMemStats(&memAfter)
+
+	rnd := rand.New(rand.NewPCG(uint64(cnt), 0))
+	hashes := make([]HashDigest, cntBenchmarkHits)
+
+	for i := range hashes {
+		binary.BigEndian.PutUint64(hashes[i][:], rnd.Uint64())
+	}
+
+	timeStart = time.Now()
+
+	for _, hash := range hashes {
+		ranges.rangeOf(hash)
+	}
+
+	timeLookup := time.Since(timeStart)
+	timeStart = time.Now()
+	isFinished := ranges.isFinished()
+	timeFinished := time.Since(timeStart)
+	bytesPerRange := float64(int64(memAfter.HeapAlloc)-int64(memBefore.HeapAlloc)) / float64(cnt)
+
+	msg := "Ranges %11d: %10.0f adds/s, %10.0f lookups/s, %5.1f bytes/range, gaps at half %v, complete %t in %v"
+	nw.out.Logf(msg, cnt, float64(cnt)/timeAdd.Seconds(), float64(cntBenchmarkHits)/timeLookup.Seconds(),
+		bytesPerRange, timeGaps.Round(time.Microsecond), isFinished, timeFinished)
+
+	err = ranges.Close()
+
+	if nw.config.IndexDir == "" || err != nil {
+		return
+	}
+
+	info, err := os.Stat(path)
+
+	if err == nil {
+		nw.out.Logf("Index file %s has %d MB, %.1f bytes/range", path, info.Size()>>20, float64(info.Size())/float64(cnt))
+	}
+
+	return
+}
+
+// benchmarkHash returns the hash at the position in a chain of evenly spread hashes
+func benchmarkHash(pos int, step uint64) string {
+	var hash HashDigest
+	binary.BigEndian.PutUint64(hash[:], uint64(pos)*step)
+
+	return encodeHash(hash)
+}
+
+// benchmarkStride scatters order of the ranges, it is coprime with cnt, so every position comes once
+func benchmarkStride(cnt int) (stride int) {
+	for stride = cnt*618/1000 + 1; gcd(stride, cnt) != 1; stride++ {
+	}
+
+	return
+}
+
+func gcd(a int, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+
+	return a
+}
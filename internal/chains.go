@@ -0,0 +1,282 @@
+package nsec3walker
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	ChangeAgreeNs    = 2  // NS servers reporting a changed range before it replaces the known one
+	RolloverCheckSec = 60 // how often an NS server returning records of another chain is asked for its NSEC3PARAM again
+)
+
+// Chain is one NSEC3 chain of the zone, there are more of them during rollovers of the salt or iterations.
+// Only the primary chain is walked, records of the other ones are collected as they come.
+type Chain struct {
+	nsec       Nsec3Params
+	ranges     *RangeIndex
+	out        *Output
+	prefix     string               // path and prefix of output files, empty without them
+	servers    map[string]time.Time // NS servers which returned records of the chain => last NSEC3PARAM check
+	cntHashes  int64
+	isFinished bool
+	isClosed   bool // was walked before a rollover, it is never rolled over to again
+}
+
+// ParamsChangeError is returned when an NS server publishes NSEC3PARAM of another chain than the walked one.
+type ParamsChangeError struct {
+	Old        Nsec3Params
+	Salt       string
+	Iterations uint16
+}
+
+func (e *ParamsChangeError) Error() string {
+	msg := "%s, salt [%s] and [%d] iterations to [%s] and [%d]"
+
+	return fmt.Sprintf(msg, ErrParamsChanged, e.Old.saltString, e.Old.iterations, e.Salt, e.Iterations)
+}
+
+func (e *ParamsChangeError) Unwrap() error {
+	return ErrParamsChanged
+}
+
+// chainFor returns the chain of the params, a new one has its own outputs tagged by the params.
+// isCheckDue is true the first time the NS server returns a record of the chain and then every RolloverCheckSec,
+// so a failed check or a server rolling over later is caught.
+func (nw *NSec3Walker) chainFor(salt string, iterations uint16, ns string) (chain *Chain, isCheckDue bool, err error) {
+	nsec, err := NewNsec3Params(nw.nsec.domain, salt, int(iterations))
+
+	if err != nil {
+		return
+	}
+
+	nw.chainsMutex.Lock()
+	defer nw.chainsMutex.Unlock()
+
+	chain, exists := nw.chains[nsec.key]
+
+	if !exists {
+		chain, err = nw.newChain(nsec)
+
+		if err != nil {
+			return
+		}
+
+		nw.chains[nsec.key] = chain
+		nw.chainOrder = append(nw.chainOrder, chain)
+	}
+
+	checkedAt, exists := chain.servers[ns]
+	isCheckDue = !exists || time.Since(checkedAt) > time.Second*RolloverCheckSec
+
+	if isCheckDue {
+		chain.servers[ns] = time.Now()
+	}
+
+	return
+}
+
+func (nw *NSec3Walker) newChain(nsec Nsec3Params) (chain *Chain, err error) {
+	ranges, err := nw.chainIndex(nsec, false)
+
+	if err != nil {
+		return
+	}
+
+	chain = &Chain{
+		nsec:    nsec,
+		ranges:  ranges,
+		out:     nw.config.Output.NewChild(nsec.key),
+		servers: make(map[string]time.Time),
+	}
+
+	if nw.config.filePathPrefix != "" {
+		chain.prefix = fmt.Sprintf("%s-salt_%s-iter_%d", nw.config.filePathPrefix, nsec.saltString, nsec.iterations)
+		err = chain.out.SetFilePrefix(chain.prefix)
+
+		if err != nil {
+			return
+		}
+
+		chain.out.Log("Logging into " + chain.prefix + ".[log,csv,hash,changes]")
+	}
+
+	nw.out.Logf("New NSEC3 chain with salt [%s] and [%d] iterations", nsec.saltString, nsec.iterations)
+
+	return
+}
+
+// setPrimaryChain makes the chain the one being walked, the first one uses the walker's own index and output.
+func (nw *NSec3Walker) setPrimaryChain(salt string, iterations uint16) (err error) {
+	nw.nsec, err = NewNsec3Params(nw.nsec.domain, salt, int(iterations))
+
+	if err != nil {
+		return
+	}
+
+	if nw.config.IndexDir != "" {
+		nw.ranges, err = nw.chainIndex(nw.nsec, nw.config.Resume)
+
+		if err != nil {
+			return
+		}
+	}
+
+	nw.ranges.cntAgree = min(len(nw.config.NameServers), ChangeAgreeNs)
+
+	nw.chain = &Chain{
+		nsec:    nw.nsec,
+		ranges:  nw.ranges,
+		out:     nw.out,
+		prefix:  nw.config.filePathPrefix,
+		servers: make(map[string]time.Time),
+	}
+
+	nw.chains[nw.nsec.key] = nw.chain
+	nw.chainOrder = append(nw.chainOrder, nw.chain)
+
+	return
+}
+
+// chainIndex returns a new range index of the chain, in a file of --index-dir if it is set.
+// With keep, the index file of a previous walk is restored, if the walk closed it.
+func (nw *NSec3Walker) chainIndex(nsec Nsec3Params, keep bool) (ranges *RangeIndex, err error) {
+	if nw.config.IndexDir == "" {
+		ranges = NewRangeIndex()
+	} else {
+		name := fmt.Sprintf("%s-salt_%s-iter_%d%s", normalizeDomain(nsec.domain), nsec.saltString, nsec.iterations, SuffixIndex)
+		ranges, _, err = OpenRangeIndex(filepath.Join(nw.config.IndexDir, name), nsec.key, keep)
+	}
+
+	if err == nil {
+		ranges.replaceOnChange = !nw.config.QuitOnChange
+		ranges.cntAgree = min(len(nw.config.NameServers), ChangeAgreeNs)
+	}
+
+	return
+}
+
+// isRollover asks the NS server for its NSEC3PARAM, another chain is rolled over to if the server publishes it.
+func (nw *NSec3Walker) isRollover(chain *Chain, ns NameServer) bool {
+	if chain == nw.chain || chain.isClosed {
+		return false
+	}
+
+	nsec3param, err := nw.client.getNsec3ParamResponse(nw.nsec.domain, ns.Address)
+
+	return err == nil && nsec3param.Salt == chain.nsec.saltString && nsec3param.Iterations == chain.nsec.iterations
+}
+
+// rollover makes the new chain the walked one. The old chain stays, its records are still collected
+// from NS servers which didn't catch up, and the new one continues with what was collected so far.
+func (nw *NSec3Walker) rollover(paramsErr *ParamsChangeError) (err error) {
+	nw.chainsMutex.Lock()
+	chain, exists := nw.chains[paramsKey(nw.nsec.domain, paramsErr.Salt, paramsErr.Iterations)]
+	nw.chainsMutex.Unlock()
+
+	if !exists {
+		return paramsErr
+	}
+
+	old := nw.nsec
+	nw.out.Logf("Closing the chain of salt [%s] and [%d] iterations with %d hashes, complete: %t",
+		old.saltString, old.iterations, nw.chain.cntHashes, nw.chain.isFinished)
+
+	nw.chain.isClosed = true
+	nw.queriesRolled += nw.stats.queries.Load()
+	nw.chain = chain
+	nw.nsec = chain.nsec
+	nw.out = chain.out
+	nw.ranges = chain.ranges
+	nw.stats = NewStats(chain.out)
+	nw.stats.onInterval = nw.emitProgress
+	nw.stats.hashes.Store(chain.cntHashes)
+	nw.client = NewDnsClient(nw.config.Transport, nw.config.EdnsSize, nw.stats)
+	nw.chanHashesFound = make(chan Nsec3Record, 1000)
+
+	msg := "Walking the new chain of salt [%s] and [%d] iterations, %d hashes known"
+	nw.out.Logf(msg, chain.nsec.saltString, chain.nsec.iterations, chain.cntHashes)
+
+	return
+}
+
+// addToChain adds the record of a chain which is not walked, so nothing is queried for it.
+// When a chain which was not walked yet gets complete, the walk rolls over to it and stops there.
+func (nw *NSec3Walker) addToChain(record Nsec3Record) {
+	chain := record.chain
+	startExists, endExists, isFull, err := chain.ranges.AddFrom(record.Start, record.End, record.Ns)
+
+	var changeErr *RangeChangeError
+
+	if errors.As(err, &changeErr) {
+		chain.out.Change(NewZoneChange(changeErr, record.Ns))
+	}
+
+	if !startExists {
+		chain.cntHashes++
+		chain.out.Hash(record.Start, chain.nsec)
+		nw.emitHash(record.Start, chain.nsec)
+	}
+
+	if !endExists {
+		chain.cntHashes++
+		chain.out.Hash(record.End, chain.nsec)
+		nw.emitHash(record.End, chain.nsec)
+	}
+
+	if isFull {
+		chain.out.Csv(record, chain.nsec)
+		nw.emitRange(record, chain.nsec)
+	}
+
+	if !chain.isFinished && chain.ranges.isFinished() {
+		chain.isFinished = true
+		chain.out.Logf("Chain is complete with %d hashes", chain.cntHashes)
+
+		if !chain.isClosed {
+			nw.cancel(&ParamsChangeError{Old: nw.nsec, Salt: chain.nsec.saltString, Iterations: chain.nsec.iterations})
+		}
+	}
+}
+
+// logChains logs state of every chain and which NS servers serve it, when there was more than one.
+func (nw *NSec3Walker) logChains() {
+	if len(nw.chainOrder) < 2 {
+		return
+	}
+
+	for _, chain := range nw.chainOrder {
+		servers := slices.Sorted(maps.Keys(chain.servers))
+		msg := "Chain with salt [%s] and [%d] iterations: %d hashes, complete: %t, served by [%s]"
+		nw.config.Output.Logf(msg, chain.nsec.saltString, chain.nsec.iterations, chain.cntHashes, chain.isFinished, strings.Join(servers, " "))
+	}
+}
+
+// closeChainOutputs closes outputs of the chains other than the first one, the config output is closed by its owner.
+func (nw *NSec3Walker) closeChainOutputs() {
+	for _, chain := range nw.chainOrder {
+		if chain.out != nw.config.Output {
+			chain.out.Close()
+		}
+	}
+}
+
+// closeChainIndexes writes out disk indexes of the chains, so the walk can be resumed from them.
+func (nw *NSec3Walker) closeChainIndexes() {
+	for _, chain := range nw.chainOrder {
+		if err := chain.ranges.Close(); err != nil {
+			nw.out.Log(err.Error())
+		}
+	}
+}
+
+func paramsKey(domain string, salt string, iterations uint16) string {
+	nsec, _ := NewNsec3Params(domain, salt, int(iterations))
+
+	return nsec.key
+}
@@ -0,0 +1,36 @@
+package nsec3walker
+
+import "time"
+
+// ZoneChange is a range which changed during the walk, usually a name was added or removed from the zone.
+type ZoneChange struct {
+	Time   time.Time
+	Start  string
+	OldEnd string
+	NewEnd string
+	Ns     string // NS server which returned the new range
+}
+
+func NewZoneChange(changeErr *RangeChangeError, ns string) (change ZoneChange) {
+	change = ZoneChange{
+		Time:   time.Now(),
+		Start:  changeErr.Start,
+		OldEnd: changeErr.OldEnd,
+		NewEnd: changeErr.NewEnd,
+		Ns:     ns,
+	}
+
+	return
+}
+
+func (zc ZoneChange) toCsv() string {
+	record := []string{
+		zc.Time.UTC().Format(time.RFC3339),
+		zc.Start,
+		zc.OldEnd,
+		zc.NewEnd,
+		zc.Ns,
+	}
+
+	return recordToCsv(record)
+}
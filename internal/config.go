@@ -5,7 +5,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/miekg/dns"
 	"github.com/spf13/cobra"
 	"golang.org/x/net/publicsuffix"
 )
@@ -16,23 +18,57 @@ const (
 	ActionDumpWordlist    = "dump-wordlist"
 	ActionHelp            = "help"
 	ActionUpdateCsv       = "update-csv"
+	ActionMigrateCsv      = "migrate-csv"
+	ActionMergeCsv        = "merge"
+	ActionVerifyCsv       = "verify"
+	ActionDiffCsv         = "diff"
 	ActionWalk            = "walk"
+	ActionWalkBatch       = "walk-batch"
 	ActionCrack           = "crack"
+	ActionBenchmarkIndex  = "benchmark-index"
+	ActionMonitor         = "monitor"
 	CntThreadsPerNs       = 3
 	CsvSeparator          = ","
+	FlagBenchmark         = "benchmark"
+	FlagBenchmarkRanges   = "benchmark-ranges"
+	FlagConcurrency       = "concurrency"
 	FlagDomain            = "domain"
+	FlagDomainsFile       = "domains-file"
 	FlagDumpDomains       = ActionDumpDomains
 	FlagDumpWordlist      = ActionDumpWordlist
+	FlagEdnsSize          = "edns-size"
 	FlagFileCsv           = "file-csv"
 	FlagFileHashcat       = "file-hashcat"
 	FlagFileWordlist      = "file-wordlist"
+	FlagFillGaps          = "fill-gaps"
+	FlagIndexDir          = "index-dir"
 	FlagNameServers       = "nameservers"
 	FlagProgress          = "progress"
+	FlagQps               = "qps"
+	FlagQpsMax            = "qps-max"
+	FlagQpsMin            = "qps-min"
+	FlagOutput            = "output"
 	FlagQuitAfter         = "quit-after"
+	FlagRecurse           = "recurse"
+	FlagRecurseDepth      = "recurse-depth"
+	FlagResume            = "resume"
 	FlagThreads           = "threads"
+	FlagTransport         = "transport"
+	FlagValidate          = "validate"
+	FlagWebhook           = "webhook"
 	FlagSalt              = "salt"
+	FlagStateDir          = "state-dir"
+	FlagStrategy          = "strategy"
 	FlagIterations        = "iterations"
+	FlagInterval          = "interval"
+	FlagIpv4Only          = "ipv4-only"
+	FlagIpv6Only          = "ipv6-only"
+	FlagJsonl             = "jsonl"
 	FlagUpdateCsv         = ActionUpdateCsv
+	FlagMigrateCsv        = ActionMigrateCsv
+	FlagMergeCsv          = ActionMergeCsv
+	FlagVerifyCsv         = ActionVerifyCsv
+	FlagDiffCsv           = ActionDiffCsv
 	GenericServers        = "8.8.8.8:53,8.8.4.4:53,1.1.1.1:53,77.88.8.8"
 	HashRegexp            = `^[0-9a-v]{32}$`
 	LogCounterIntervalSec = 30
@@ -46,6 +82,7 @@ Main commands:
   walk        Walk zone for a domain
   file        Process CSV & Hashcat files
   crack       Simple build in cracking of hashes using a wordlist
+  monitor     Re-walk zone periodically and report changes
 
 Additional commands:
   debug       Show debug information for a domain
@@ -55,19 +92,45 @@ Additional commands:
 
 type Config struct {
 	Action                string
+	Concurrency           int
 	Domain                string
 	DomainDnsServers      []string
+	DomainsFile           string
+	EdnsSize              int
 	FileCsv               string
 	FileHashcat           string
 	FileWordlist          string
+	FillGaps              string
+	Hooks                 Hooks
+	IndexDir              string
+	Interval              time.Duration
+	Ipv4Only              bool
+	Ipv6Only              bool
+	Jsonl                 string
 	LogCounterIntervalSec int
+	NameServers           []NameServer
 	Output                *Output
+	QpsInitial            float64
+	QpsMax                float64
+	QpsMin                float64
 	QuitAfterMin          int
 	QuitOnChange          bool
+	Recurse               bool
+	RecurseDepth          int
+	Resume                bool
+	StateDir              string
+	Strategy              string
+	Transport             string
+	Validate              bool
 	Verbose               bool
+	Webhook               string
 
+	benchmark          bool
+	benchmarkRanges    []int
 	cntThreadsPerNs    int
 	debugDomain        string
+	diffCsv            bool
+	diffFiles          []string
 	domainServerInput  string
 	dumpDomains        bool
 	dumpWordlist       bool
@@ -75,7 +138,13 @@ type Config struct {
 	genericDnsServers  []string
 	genericServerInput string
 	help               bool
+	jsonlSink          *JsonlSink
 	updateCsv          bool
+	migrateCsv         bool
+	mergeCsv           bool
+	mergeFiles         []string
+	mergePrefix        string
+	verifyCsv          bool
 	Salt               string
 	Iterations         int
 }
@@ -105,6 +174,7 @@ func NewConfig() (config *Config, err error) {
 		cmdFile(config),
 		cmdDebug(config),
 		cmdCrack(config),
+		cmdMonitor(config),
 	)
 
 	err = cmd.Execute()
@@ -119,31 +189,302 @@ func NewConfig() (config *Config, err error) {
 		return config, nil
 	}
 
-	config.Output.SetVerbose(config.Verbose)
+	// walks are prepared by the public walker package, main maps the flags to its options
+	if config.Action != ActionWalk {
+		err = config.Prepare()
+	}
+
+	return
+}
+
+// NewWalkConfig returns config for walking the domain with the same defaults as the walk command has,
+// for embedding the walker without the command line. Call Prepare once the values are set.
+func NewWalkConfig(domain string) (config *Config) {
+	config = &Config{
+		Action:                ActionWalk,
+		Concurrency:           Concurrency,
+		Domain:                domain,
+		EdnsSize:              EdnsSize,
+		LogCounterIntervalSec: LogCounterIntervalSec,
+		Output:                NewOutput(),
+		QpsInitial:            QpsInitial,
+		QpsMax:                QpsMax,
+		QpsMin:                QpsMin,
+		QuitAfterMin:          QuitAfterMin,
+		RecurseDepth:          RecurseDepth,
+		Strategy:              StrategyGaps,
+		Transport:             TransportAuto,
+		cntThreadsPerNs:       CntThreadsPerNs,
+		genericServerInput:    GenericServers,
+	}
+
+	return
+}
+
+// SetNameServers sets authoritative NS servers of the domain, instead of looking them up via resolvers.
+func (cnf *Config) SetNameServers(servers []string) {
+	cnf.domainServerInput = strings.Join(servers, ",")
+}
+
+// GetNameServers returns authoritative NS servers given on the command line.
+func (cnf *Config) GetNameServers() []string {
+	return cnf.parseServersValue(cnf.domainServerInput)
+}
+
+// SetResolvers sets generic DNS resolvers used for looking up NS servers of the domain.
+func (cnf *Config) SetResolvers(servers []string) {
+	cnf.genericServerInput = strings.Join(servers, ",")
+}
+
+func (cnf *Config) GetResolvers() []string {
+	return cnf.parseServersValue(cnf.genericServerInput)
+}
+
+func (cnf *Config) SetThreadsPerNs(threads int) {
+	cnf.cntThreadsPerNs = threads
+}
+
+func (cnf *Config) GetThreadsPerNs() int {
+	return cnf.cntThreadsPerNs
+}
+
+// SetFilePrefix sets path and prefix of output files, opened by Prepare.
+func (cnf *Config) SetFilePrefix(prefix string) {
+	cnf.filePathPrefix = prefix
+}
+
+func (cnf *Config) GetFilePrefix() string {
+	return cnf.filePathPrefix
+}
+
+// Prepare checks the values and opens output files.
+func (cnf *Config) Prepare() (err error) {
+	cnf.Output.SetVerbose(cnf.Verbose)
 
-	if config.filePathPrefix != "" {
-		config.filePathPrefix, err = GetOutputFilePrefix(config.filePathPrefix, config.Domain)
+	if cnf.Jsonl != "" {
+		err = cnf.addJsonlSink()
+
+		if err != nil {
+			return
+		}
+	}
+
+	if cnf.FillGaps != "" {
+		err = cnf.prepareFillGaps()
+
+		if err != nil {
+			return
+		}
+	}
+
+	if cnf.Resume && cnf.filePathPrefix == "" {
+		return fmt.Errorf("--%s requires --%s with the prefix of the interrupted walk", FlagResume, FlagOutput)
+	}
+
+	if cnf.DomainsFile != "" {
+		if cnf.Resume {
+			return fmt.Errorf("--%s can't be used with --%s", FlagResume, FlagDomainsFile)
+		}
+
+		cnf.Action = ActionWalkBatch
+		err = cnf.setOutputDirectory()
+
+		if err != nil {
+			return
+		}
+	} else if cnf.filePathPrefix != "" {
+		if cnf.Resume {
+			cnf.filePathPrefix, err = GetResumeFilePrefix(cnf.filePathPrefix, cnf.Domain)
+
+			if err == nil {
+				err = checkCsvAppendable(cnf.filePathPrefix + SuffixCsv)
+			}
+		} else {
+			cnf.filePathPrefix, err = GetOutputFilePrefix(cnf.filePathPrefix, cnf.Domain)
+		}
 
 		if err == nil {
-			err = config.Output.SetFilePrefix(config.filePathPrefix)
+			err = cnf.Output.SetFilePrefix(cnf.filePathPrefix)
 		}
 
 		if err != nil {
 			return
 		}
 
-		config.Output.Log("Logging into " + config.filePathPrefix + ".[log,csv,hash]")
+		cnf.Output.Log("Logging into " + cnf.filePathPrefix + ".[log,csv,hash,changes]")
+	}
+
+	if cnf.Action == ActionMonitor {
+		err = cnf.checkMonitorValues()
+
+		if err != nil {
+			return
+		}
+	}
+
+	if cnf.Action == ActionWalk || cnf.Action == ActionWalkBatch || cnf.Action == ActionDebug || cnf.Action == ActionMonitor {
+		err = cnf.checkDnsValues()
+
+		if err != nil {
+			return
+		}
 	}
 
 	errs := []error{
-		ValueMustBePositive(config.LogCounterIntervalSec, FlagProgress),
-		ValueMustBePositive(config.QuitAfterMin, FlagQuitAfter),
-		ValueMustBePositive(config.cntThreadsPerNs, FlagThreads),
+		ValueMustBePositive(cnf.LogCounterIntervalSec, FlagProgress),
+		ValueMustBePositive(cnf.QuitAfterMin, FlagQuitAfter),
+		ValueMustBePositive(cnf.cntThreadsPerNs, FlagThreads),
+		ValueMustBePositive(cnf.Concurrency, FlagConcurrency),
 	}
 
 	for _, errX := range errs {
 		if errX != nil {
-			return config, errX
+			return errX
+		}
+	}
+
+	if cnf.Action == ActionWalk || cnf.Action == ActionWalkBatch || cnf.Action == ActionMonitor {
+		err = cnf.checkWalkValues()
+	}
+
+	if err == nil && cnf.Recurse {
+		err = cnf.checkRecurseValues()
+	}
+
+	return
+}
+
+// prepareFillGaps resumes the walk from the CSV file, only its gaps are queried and new ranges are appended to it.
+func (cnf *Config) prepareFillGaps() (err error) {
+	if cnf.Resume || cnf.filePathPrefix != "" || cnf.DomainsFile != "" {
+		return fmt.Errorf("--%s can't be used with --%s, --%s or --%s", FlagFillGaps, FlagResume, FlagOutput, FlagDomainsFile)
+	}
+
+	if !strings.HasSuffix(cnf.FillGaps, SuffixCsv) {
+		return fmt.Errorf("--%s must be a %s file", FlagFillGaps, SuffixCsv)
+	}
+
+	domain, err := readCsvDomain(cnf.FillGaps)
+
+	if err != nil {
+		return
+	}
+
+	if cnf.Domain != "" && normalizeDomain(cnf.Domain) != domain {
+		return fmt.Errorf("--%s is [%s], but the CSV file is for [%s]", FlagDomain, cnf.Domain, domain)
+	}
+
+	cnf.Domain = domain
+	cnf.Resume = true
+	cnf.Strategy = StrategyGaps
+	cnf.filePathPrefix = strings.TrimSuffix(cnf.FillGaps, SuffixCsv)
+
+	return
+}
+
+func (cnf *Config) checkMonitorValues() (err error) {
+	if cnf.Interval < time.Minute {
+		return fmt.Errorf("--%s must be at least a minute", FlagInterval)
+	}
+
+	if cnf.Webhook != "" && !strings.HasPrefix(cnf.Webhook, "http://") && !strings.HasPrefix(cnf.Webhook, "https://") {
+		return fmt.Errorf("--%s must be a http:// or https:// URL", FlagWebhook)
+	}
+
+	cnf.StateDir, err = filepath.Abs(filepath.Clean(cnf.StateDir))
+
+	if err == nil {
+		err = os.MkdirAll(cnf.StateDir, PermDir)
+	}
+
+	return
+}
+
+// checkRecurseValues checks values for walking the child zones, they use defaults of the walk flags.
+func (cnf *Config) checkRecurseValues() (err error) {
+	if cnf.Action != ActionCrack && cnf.Action != ActionUpdateCsv || cnf.FileCsv == "" {
+		return fmt.Errorf("--%s works only with a CSV file being cracked or updated", FlagRecurse)
+	}
+
+	err = ValueMustBePositive(cnf.RecurseDepth, FlagRecurseDepth)
+
+	if err == nil {
+		err = cnf.checkDnsValues()
+	}
+
+	if err == nil {
+		err = cnf.checkWalkValues()
+	}
+
+	return
+}
+
+func (cnf *Config) addJsonlSink() (err error) {
+	sink, err := NewJsonlSink(cnf.Jsonl)
+
+	if err != nil {
+		return
+	}
+
+	if cnf.Jsonl == JsonlStdout {
+		cnf.Output.SetSilent(true) // stdout is for JSON only
+	}
+
+	cnf.jsonlSink = sink
+	cnf.Output.AddSink(sink)
+
+	return
+}
+
+// setOutputDirectory makes the --output a directory for batch walks, every zone gets its own prefix in it.
+func (cnf *Config) setOutputDirectory() (err error) {
+	if cnf.filePathPrefix == "" {
+		return
+	}
+
+	cnf.filePathPrefix, err = filepath.Abs(filepath.Clean(cnf.filePathPrefix))
+
+	if err == nil {
+		err = os.MkdirAll(cnf.filePathPrefix, PermDir)
+	}
+
+	if err == nil {
+		cnf.Output.Log("Logging zones into " + cnf.filePathPrefix)
+	}
+
+	return
+}
+
+func (cnf *Config) checkDnsValues() (err error) {
+	if cnf.Transport != TransportAuto && cnf.Transport != TransportUdp && cnf.Transport != TransportTcp {
+		return fmt.Errorf("--%s must be %s, %s or %s", FlagTransport, TransportUdp, TransportTcp, TransportAuto)
+	}
+
+	if cnf.EdnsSize < dns.MinMsgSize || cnf.EdnsSize > dns.MaxMsgSize {
+		return fmt.Errorf("--%s must be between %d and %d", FlagEdnsSize, dns.MinMsgSize, dns.MaxMsgSize)
+	}
+
+	return
+}
+
+func (cnf *Config) checkWalkValues() (err error) {
+	if cnf.Strategy != StrategyGaps && cnf.Strategy != StrategySequential {
+		return fmt.Errorf("--%s must be %s or %s", FlagStrategy, StrategyGaps, StrategySequential)
+	}
+
+	if cnf.QpsMin <= 0 {
+		return fmt.Errorf("--%s must be a positive number", FlagQpsMin)
+	}
+
+	if cnf.QpsMin > cnf.QpsInitial || cnf.QpsInitial > cnf.QpsMax {
+		return fmt.Errorf("--%s must be between --%s and --%s", FlagQps, FlagQpsMin, FlagQpsMax)
+	}
+
+	if cnf.IndexDir != "" {
+		cnf.IndexDir, err = filepath.Abs(filepath.Clean(cnf.IndexDir))
+
+		if err == nil {
+			err = os.MkdirAll(cnf.IndexDir, PermDir)
 		}
 	}
 
@@ -170,6 +511,8 @@ func cmdDebug(config *Config) *cobra.Command {
 	addCommonFlags(cmd, config)
 	addDomainFlags(cmd, config)
 
+	_ = cmd.MarkFlagRequired(FlagDomain)
+
 	return cmd
 }
 
@@ -190,41 +533,132 @@ func cmdWalk(config *Config) *cobra.Command {
 		},
 	}
 
-	msgInt := "Counters print interval in seconds"
 	msgPath := "Path and prefix for output files. ../directory/prefix"
+	msgResume := "Resume an interrupted walk from the existing output files of --" + FlagOutput + ", the latest walk of the domain in a directory"
+	msgJsonl := "Write ranges, logs and progress as JSON Lines into the file, - for stdout"
+
+	cmd.Flags().StringVarP(&config.filePathPrefix, FlagOutput, "o", "", msgPath+", a directory with --"+FlagDomainsFile)
+	cmd.Flags().StringVar(&config.DomainsFile, FlagDomainsFile, "", "Walk zones from a file, one domain per line")
+	cmd.Flags().IntVar(&config.Concurrency, FlagConcurrency, Concurrency, "How many zones from --"+FlagDomainsFile+" are walked at once")
+	cmd.Flags().BoolVar(&config.QuitOnChange, "quit-on-change", false, "Quit if the zone changed, instead of recording the changes")
+	cmd.Flags().BoolVar(&config.Resume, FlagResume, false, msgResume)
+	cmd.Flags().StringVar(&config.FillGaps, FlagFillGaps, "", "Walk only the gaps of an incomplete CSV file, new ranges are appended to it")
+	cmd.Flags().StringVar(&config.Jsonl, FlagJsonl, "", msgJsonl)
+	addWalkFlags(cmd, config)
+	addCommonFlags(cmd, config)
+	addDomainFlags(cmd, config)
 
-	cmd.Flags().IntVar(&config.LogCounterIntervalSec, FlagProgress, LogCounterIntervalSec, msgInt)
-	cmd.Flags().IntVar(&config.QuitAfterMin, FlagQuitAfter, QuitAfterMin, "Quit after X minutes of no new hashes")
-	cmd.Flags().StringVarP(&config.filePathPrefix, "output", "o", "", msgPath)
-	cmd.Flags().BoolVar(&config.QuitOnChange, "quit-on-change", false, "Quit if the zone changed")
-	cmd.Flags().IntVarP(&config.cntThreadsPerNs, FlagThreads, "t", CntThreadsPerNs, "[WIP] Threads per NS server")
+	cmd.MarkFlagsOneRequired(FlagDomain, FlagDomainsFile, FlagFillGaps)
+	cmd.MarkFlagsMutuallyExclusive(FlagDomain, FlagDomainsFile)
+	cmd.MarkFlagsMutuallyExclusive(FlagFillGaps, FlagDomainsFile)
+
+	return cmd
+}
+
+func cmdMonitor(config *Config) *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "monitor [flags]",
+		Short: "Re-walk zone periodically and report changes",
+		Long:  "Re-walk zone periodically, keep snapshots in --" + FlagStateDir + " and report changes against the previous one. Provide --domain.",
+		Run: func(cmd *cobra.Command, args []string) {
+			config.Action = ActionMonitor
+
+			if len(args) == 0 && cmd.Flags().NFlag() == 0 {
+				config.help = true
+				_ = cmd.Help()
+
+				return
+			}
+		},
+	}
+
+	msgStateDir := "Directory for snapshots, the latest complete one and changes in <domain>" + SuffixEvents
+	msgWebhook := "URL getting changes of every snapshot as JSON via POST"
+
+	cmd.Flags().DurationVar(&config.Interval, FlagInterval, MonitorInterval, "How often the zone is walked")
+	cmd.Flags().StringVar(&config.StateDir, FlagStateDir, "", msgStateDir)
+	cmd.Flags().StringVar(&config.Webhook, FlagWebhook, "", msgWebhook)
+	addWalkFlags(cmd, config)
 	addCommonFlags(cmd, config)
 	addDomainFlags(cmd, config)
 
 	_ = cmd.MarkFlagRequired(FlagDomain)
+	_ = cmd.MarkFlagRequired(FlagStateDir)
 
 	return cmd
 }
 
 func cmdFile(config *Config) *cobra.Command {
 	var cmd = &cobra.Command{
-		Use:           "file [flags]",
+		Use:           "file [flags] [csv files to merge or diff]",
 		Short:         "Process CSV & Hashcat files",
 		Long:          "Processing of files - dump plaintext domains, update CSV file.",
 		SilenceErrors: true,
 		Run:           func(cmd *cobra.Command, args []string) {},
 		PostRunE: func(cmd *cobra.Command, args []string) error {
-			options := fmt.Sprintf("--%s , --%s or --%s", FlagUpdateCsv, FlagDumpDomains, FlagDumpWordlist)
-			if moreThanOne(config.updateCsv, config.dumpDomains, config.dumpWordlist) {
+			flags := []any{FlagUpdateCsv, FlagMigrateCsv, FlagMergeCsv, FlagVerifyCsv, FlagDiffCsv, FlagDumpDomains, FlagDumpWordlist, FlagBenchmark}
+			options := fmt.Sprintf("--%s , --%s , --%s , --%s , --%s , --%s , --%s or --%s", flags...)
+			actions := []bool{config.updateCsv, config.migrateCsv, config.mergeCsv, config.verifyCsv, config.diffCsv, config.dumpDomains, config.dumpWordlist, config.benchmark}
+			if moreThanOne(actions...) {
 				return fmt.Errorf("Specify only one of %s", options)
 			}
 
+			if config.benchmark {
+				for _, cnt := range config.benchmarkRanges {
+					if cnt < 1 {
+						return fmt.Errorf("--%s must be positive numbers", FlagBenchmarkRanges)
+					}
+				}
+
+				config.Action = ActionBenchmarkIndex
+
+				if config.IndexDir != "" {
+					return os.MkdirAll(config.IndexDir, PermDir)
+				}
+
+				return nil
+			}
+
+			if config.diffCsv {
+				if len(args) != 2 {
+					return fmt.Errorf("Specify the old and the new CSV file to diff")
+				}
+
+				config.diffFiles = args
+				config.Action = ActionDiffCsv
+
+				return nil
+			}
+
+			if config.mergeCsv {
+				if len(args) == 0 || config.mergePrefix == "" {
+					return fmt.Errorf("Specify CSV files to merge and --%s for the merged files", FlagOutput)
+				}
+
+				config.mergeFiles = args
+				config.Action = ActionMergeCsv
+
+				return nil
+			}
+
 			if config.FileCsv == "" && config.FileHashcat == "" {
 				return fmt.Errorf("Specify --%s or --%s", FlagFileCsv, FlagFileHashcat)
 			}
 
 			if config.updateCsv {
 				config.Action = ActionUpdateCsv
+			} else if config.migrateCsv {
+				if config.FileCsv == "" {
+					return fmt.Errorf("Specify --%s to migrate", FlagFileCsv)
+				}
+
+				config.Action = ActionMigrateCsv
+			} else if config.verifyCsv {
+				if config.FileCsv == "" {
+					return fmt.Errorf("Specify --%s to verify", FlagFileCsv)
+				}
+
+				config.Action = ActionVerifyCsv
 			} else if config.dumpDomains || config.dumpWordlist {
 				if config.dumpDomains {
 					config.Action = ActionDumpDomains
@@ -242,9 +676,19 @@ func cmdFile(config *Config) *cobra.Command {
 	cmd.Flags().BoolVar(&config.dumpDomains, FlagDumpDomains, false, "Dump plaintext domains from files (CSV, Hashcat)")
 	cmd.Flags().BoolVar(&config.dumpWordlist, FlagDumpWordlist, false, "Extract domain parts for cracking wordlists from files (CSV, Hashcat)")
 	cmd.Flags().BoolVar(&config.updateCsv, FlagUpdateCsv, false, "Update CSV file with plaintext domains from Hashcat")
+	cmd.Flags().BoolVar(&config.migrateCsv, FlagMigrateCsv, false, "Upgrade CSV file to the current format with a header")
+	cmd.Flags().BoolVar(&config.mergeCsv, FlagMergeCsv, false, "Merge CSV files of partial walks of the same zone given as arguments")
+	cmd.Flags().BoolVar(&config.verifyCsv, FlagVerifyCsv, false, "Check the chain in CSV file is complete, list gaps, exit with an error if it is not")
+	cmd.Flags().BoolVar(&config.diffCsv, FlagDiffCsv, false, "Compare two walks of the same zone, old and new CSV file given as arguments")
+	cmd.Flags().BoolVar(&config.benchmark, FlagBenchmark, false, "Measure speed and memory of the range index, it needs a lot of RAM for 100M ranges")
+	cmd.Flags().IntSliceVar(&config.benchmarkRanges, FlagBenchmarkRanges, BenchmarkRanges, "Comma-separated sizes of the index for --"+FlagBenchmark)
+	cmd.Flags().StringVar(&config.IndexDir, FlagIndexDir, "", "Benchmark the disk index in the directory, instead of the one in memory")
+	cmd.Flags().StringVar(&config.Jsonl, FlagJsonl, "", "Write differences and logs as JSON Lines into the file, - for stdout")
+	cmd.Flags().StringVarP(&config.mergePrefix, FlagOutput, "o", "", "Path and prefix for merged files. ../directory/prefix")
 	cmd.Flags().StringVar(&config.FileHashcat, FlagFileHashcat, "", "A Hashcat .potfile file containing cracked hashes")
 	cmd.Flags().StringVar(&config.FileCsv, FlagFileCsv, "", "A nsec3walker .csv file")
 	addCommonFlags(cmd, config)
+	addRecurseFlags(cmd, config)
 
 	return cmd
 }
@@ -260,7 +704,7 @@ func cmdCrack(config *Config) *cobra.Command {
 			hasAllFile := config.FileCsv != "" && config.FileWordlist != ""
 			hasAllParams := config.Domain != "" && config.Salt != "" && config.Iterations != 0
 
-			if !hasAllFile && !hasAllParams {
+			if !hasAllFile && !hasAllParams && !config.benchmark {
 				msg := "Specify either (--%s & --%s) or [--%s & --%s & --%s]"
 				return fmt.Errorf(msg, FlagFileCsv, FlagFileWordlist, FlagDomain, FlagSalt, FlagIterations)
 			}
@@ -275,7 +719,9 @@ func cmdCrack(config *Config) *cobra.Command {
 	cmd.Flags().StringVar(&config.Domain, FlagDomain, "", "Domain")
 	cmd.Flags().StringVarP(&config.Salt, FlagSalt, "s", "", "Salt for hash")
 	cmd.Flags().IntVarP(&config.Iterations, FlagIterations, "i", 0, "Iterations for hash")
+	cmd.Flags().BoolVar(&config.benchmark, FlagBenchmark, false, "Measure hashes per second at 0, 10, 100 and 2500 iterations")
 	addCommonFlags(cmd, config)
+	addRecurseFlags(cmd, config)
 
 	return cmd
 }
@@ -284,14 +730,43 @@ func addCommonFlags(cmd *cobra.Command, config *Config) {
 	cmd.Flags().BoolVarP(&config.Verbose, "verbose", "v", false, "Verbose")
 }
 
+// addWalkFlags adds flags tuning the walk itself, for commands which walk a zone.
+func addWalkFlags(cmd *cobra.Command, config *Config) {
+	msgInt := "Counters print interval in seconds"
+	msgStrategy := fmt.Sprintf("How to pick domains to query, %s (largest uncovered gaps first) or %s", StrategyGaps, StrategySequential)
+	msgValidate := "Validate RRSIGs of NSEC3 records against the zone DNSKEY set anchored by the parent DS, invalid records are discarded"
+	msgIndexDir := "Keep range indexes in files in the directory, for zones larger than memory. --" + FlagResume + " continues from them"
+
+	cmd.Flags().IntVar(&config.LogCounterIntervalSec, FlagProgress, LogCounterIntervalSec, msgInt)
+	cmd.Flags().IntVar(&config.QuitAfterMin, FlagQuitAfter, QuitAfterMin, "Quit after X minutes of no new hashes")
+	cmd.Flags().IntVarP(&config.cntThreadsPerNs, FlagThreads, "t", CntThreadsPerNs, "[WIP] Threads per NS server")
+	cmd.Flags().BoolVar(&config.Ipv4Only, FlagIpv4Only, false, "Walk only IPv4 addresses of NS servers")
+	cmd.Flags().BoolVar(&config.Ipv6Only, FlagIpv6Only, false, "Walk only IPv6 addresses of NS servers")
+	cmd.MarkFlagsMutuallyExclusive(FlagIpv4Only, FlagIpv6Only)
+	cmd.Flags().StringVar(&config.Strategy, FlagStrategy, StrategyGaps, msgStrategy)
+	cmd.Flags().BoolVar(&config.Validate, FlagValidate, false, msgValidate)
+	cmd.Flags().StringVar(&config.IndexDir, FlagIndexDir, "", msgIndexDir)
+	cmd.Flags().Float64Var(&config.QpsInitial, FlagQps, QpsInitial, "Initial queries per second for each NS server")
+	cmd.Flags().Float64Var(&config.QpsMin, FlagQpsMin, QpsMin, "Minimal queries per second, used for failing NS servers")
+	cmd.Flags().Float64Var(&config.QpsMax, FlagQpsMax, QpsMax, "Maximal queries per second for well answering NS servers")
+}
+
+func addRecurseFlags(cmd *cobra.Command, config *Config) {
+	msgRecurse := "Walk NSEC3 signed delegations with cracked names from the CSV, then crack them the same way"
+
+	cmd.Flags().BoolVar(&config.Recurse, FlagRecurse, false, msgRecurse)
+	cmd.Flags().IntVar(&config.RecurseDepth, FlagRecurseDepth, RecurseDepth, "How many levels of delegations to walk")
+}
+
 func addDomainFlags(cmd *cobra.Command, config *Config) {
 	msgServ := "Comma-separated list of generic DNS resolvers"
 	msgRes := "Comma-separated list of custom authoritative NS servers for the domain"
 
 	cmd.Flags().StringVar(&config.Domain, FlagDomain, "", "Domain")
-	_ = cmd.MarkFlagRequired(FlagDomain) // would return err if FlagDomain wasn't defined above
 	cmd.Flags().StringVar(&config.genericServerInput, "resolvers", GenericServers, msgServ)
 	cmd.Flags().StringVar(&config.domainServerInput, FlagNameServers, "", msgRes)
+	cmd.Flags().StringVar(&config.Transport, FlagTransport, TransportAuto, "DNS transport: udp, tcp or auto (TCP on truncation)")
+	cmd.Flags().IntVar(&config.EdnsSize, FlagEdnsSize, EdnsSize, "EDNS buffer size for UDP queries")
 
 	return
 }
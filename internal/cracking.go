@@ -3,6 +3,7 @@ package nsec3walker
 import (
 	"fmt"
 	"os"
+	"runtime"
 	"strings"
 	"sync"
 )
@@ -13,9 +14,8 @@ type Cracking struct {
 	fileWordlist *os.File
 	csv          *Csv
 	chanWords    chan string
-	chanCsv      chan CsvItem
 	wgFile       sync.WaitGroup
-	hashes       map[string]map[string]string
+	hashes       map[string]map[HashDigest]bool // Nsec3Params.key => raw hashes from the CSV
 	nsec3params  map[string]Nsec3Params
 	wgCracker    sync.WaitGroup
 	cracked      *Cracked
@@ -26,8 +26,7 @@ func NewCracking(cnf *Config, out *Output) (c *Cracking) {
 		cnf:         cnf,
 		out:         out,
 		chanWords:   make(chan string, 1000),
-		chanCsv:     make(chan CsvItem, 1000),
-		hashes:      make(map[string]map[string]string),
+		hashes:      make(map[string]map[HashDigest]bool),
 		nsec3params: make(map[string]Nsec3Params),
 		cracked:     NewCracked(),
 	}
@@ -39,7 +38,9 @@ func (c *Cracking) Run() (err error) {
 	hasFile := c.cnf.FileWordlist != ""
 	hasDomain := c.cnf.Domain != ""
 
-	if hasFile {
+	if c.cnf.benchmark {
+		return c.runBenchmark()
+	} else if hasFile {
 		return c.runWordlist()
 	} else if hasDomain {
 		return c.runSingle()
@@ -66,10 +67,9 @@ func (c *Cracking) runWordlist() (err error) {
 		}
 	})
 
-	c.wgCracker.Add(3)
-	go c.runCracker()
-	go c.runCracker()
-	go c.runCracker()
+	for i := 0; i < runtime.NumCPU(); i++ {
+		c.wgCracker.Go(c.runCracker)
+	}
 
 	c.wgCracker.Wait()
 
@@ -91,28 +91,40 @@ func (c *Cracking) prepareCsv() (err error) {
 		return
 	}
 
-	go func() {
-		errRead := c.csv.ReadToChan(c.chanCsv, true)
-		if errRead != nil {
-			c.out.Log(errRead.Error())
+	err = c.csv.ForEach(func(csvItem CsvItem) error {
+		if csvItem.Hash == "" {
+			return nil // cleartext names of a NSEC walk
 		}
-	}()
 
-	var n3p Nsec3Params
-	for csvItem := range c.chanCsv {
-		n3p, err = NewNsec3Params(csvItem.Domain, csvItem.Salt, csvItem.Iterations)
-		if err != nil {
-			return
+		errRow := c.addHash(csvItem)
+		if errRow != nil {
+			c.out.Logf("Skipping hash %s: %v", csvItem.Hash, errRow)
 		}
 
-		if c.hashes[n3p.key] == nil {
-			c.hashes[n3p.key] = make(map[string]string)
-			c.nsec3params[n3p.key] = n3p
-		}
+		return nil
+	})
+
+	return
+}
+
+func (c *Cracking) addHash(csvItem CsvItem) (err error) {
+	n3p, err := NewNsec3Params(csvItem.Domain, csvItem.Salt, csvItem.Iterations)
+	if err != nil {
+		return
+	}
+
+	digest, err := DecodeHash(csvItem.Hash)
+	if err != nil {
+		return
+	}
 
-		c.hashes[n3p.key][csvItem.Hash] = ""
+	if c.hashes[n3p.key] == nil {
+		c.hashes[n3p.key] = make(map[HashDigest]bool)
+		c.nsec3params[n3p.key] = n3p
 	}
 
+	c.hashes[n3p.key][digest] = true
+
 	return
 }
 
@@ -141,7 +153,7 @@ func (c *Cracking) runSingle() (err error) {
 
 	msg := "Get hash for domain [%s] with salt [%s] having [%d] iterations.\n"
 	c.out.Logf(msg, n3p.domain, n3p.saltString, n3p.iterations)
-	hash, err := n3p.CalculateHashForPrefix(n3p.domain)
+	hash, err := n3p.CalculateHash(n3p.domain)
 	if err != nil {
 		return
 	}
@@ -156,23 +168,31 @@ func (c *Cracking) runSingle() (err error) {
 	return
 }
 
+// runCracker hashes words as the first label of every zone, comparing raw digests, only hits are encoded.
 func (c *Cracking) runCracker() {
+	hashers := make(map[string]*Nsec3Hasher)
+
+	for key, n3p := range c.nsec3params {
+		hasher, err := n3p.NewHasher()
+		if err != nil {
+			c.out.Log(err.Error())
+
+			return
+		}
+
+		hashers[key] = hasher
+	}
+
 	for word := range c.chanWords {
-		for _, n3p := range c.nsec3params {
-			domain := strings.TrimLeft(word+"."+n3p.domain, ".")
-			hash, err := n3p.CalculateHashForPrefix(domain)
+		for key, hasher := range hashers {
+			digest, err := hasher.DigestPrefix(word)
 			if err != nil {
-				return
-			}
-
-			plaintext, ok := c.hashes[n3p.key][hash]
-			if ok == false || plaintext == word {
 				continue
 			}
 
-			c.cracked.Add(n3p, hash, word)
+			if c.hashes[key][digest] {
+				c.cracked.Add(c.nsec3params[key], hasher.Encode(digest), word)
+			}
 		}
 	}
-
-	c.wgCracker.Done()
 }
@@ -0,0 +1,63 @@
+package nsec3walker
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// testCsvFile writes the rows into a CSV file with a header, as the walk does
+func testCsvFile(t *testing.T, rows ...string) (filePath string) {
+	t.Helper()
+
+	filePath = filepath.Join(t.TempDir(), "t"+SuffixCsv)
+	file, err := NewFile(filePath, BuffSizeCsv)
+
+	if err == nil {
+		err = writeCsvHeader(file)
+	}
+
+	for _, row := range rows {
+		if err == nil {
+			err = file.Write(row + "\n")
+		}
+	}
+
+	if err == nil {
+		err = file.Close()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func testCsvRow(hash string, hashNext string) string {
+	item := CsvItem{Hash: hash, HashNext: hashNext, Domain: "example.com", Salt: "aabb", Iterations: 1}
+
+	return item.toCsv()
+}
+
+// TestCrackingPrepareCsvSkipsBadRows checks rows after one with invalid params are still loaded
+func TestCrackingPrepareCsvSkipsBadRows(t *testing.T) {
+	h := testChain(4)
+	bad := CsvItem{Hash: h[2], HashNext: h[3], Domain: "example.com", Salt: "xyz", Iterations: 1}
+	filePath := testCsvFile(t, testCsvRow(h[0], h[1]), bad.toCsv(), testCsvRow(h[3], h[0]))
+
+	config := NewWalkConfig("")
+	config.FileCsv = filePath
+	config.Output.SetLogger(func(string) {})
+
+	c := NewCracking(config, config.Output)
+
+	if err := c.prepareCsv(); err != nil {
+		t.Fatal(err)
+	}
+
+	n3p, _ := NewNsec3Params("example.com", "aabb", 1)
+
+	if len(c.hashes) != 1 || len(c.hashes[n3p.key]) != 2 {
+		t.Fatalf("loaded %d hashes of %d params, want 2 hashes", len(c.hashes[n3p.key]), len(c.hashes))
+	}
+}
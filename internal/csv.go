@@ -1,7 +1,9 @@
 package nsec3walker
 
 import (
-	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -11,13 +13,23 @@ import (
 )
 
 const (
-	CntCsvFileParts = 7
+	CsvVersion            = "v2"
+	CsvVersionPrefix      = "#nsec3walker-csv"
+	CsvTypesSeparator     = "|"
+	CntCsvFileParts       = 8
+	CntCsvFilePartsLegacy = 7 // before the validation column
 )
 
+// CsvVersionLine is the first line of CSV files, CsvHeader follows it. Files without it are in the older headerless format.
+var CsvVersionLine = CsvVersionPrefix + " " + CsvVersion
+
+var CsvHeader = []string{"hash", "hash_next", "domain", "salt", "iterations", "plaintext", "types", "validation"}
+
 type Csv struct {
 	FileInput *CsvFile
 	FileTemp  *CsvFile
 	out       *Output
+	version   string // of the version line, empty for headerless files
 }
 
 type CsvFile struct {
@@ -35,6 +47,7 @@ type CsvItem struct {
 	Iterations int
 	Plaintext  string
 	Types      []string
+	Validation string
 }
 
 func NewCsvFile(filePath string, isNew bool) (csvFile *CsvFile, err error) {
@@ -70,6 +83,10 @@ func NewCsvFile(filePath string, isNew bool) (csvFile *CsvFile, err error) {
 func NewCsv(csvFilePath string, out *Output) (csv *Csv, err error) {
 	csvFileRes, err := NewCsvFile(csvFilePath, false)
 
+	if err != nil {
+		return
+	}
+
 	csv = &Csv{
 		FileInput: csvFileRes,
 		out:       out,
@@ -94,42 +111,52 @@ func NewCsv(csvFilePath string, out *Output) (csv *Csv, err error) {
 	return
 }
 
-func (c *Csv) ReadToChan(chanCsvItem chan CsvItem, closeChan bool) (err error) {
+// ForEach calls fn with every row of the file, it stops at the first error of fn or of reading the file.
+func (c *Csv) ForEach(fn func(csvItem CsvItem) error) (err error) {
 	_, err = c.FileInput.Resource.Seek(0, io.SeekStart)
 
 	if err != nil {
 		return fmt.Errorf("Failed to seek to the beginning of the file: %v", err)
 	}
 
-	scanner := bufio.NewScanner(c.FileInput.Resource)
+	reader := newCsvReader(c.FileInput.Resource)
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+	for {
+		record, errRead := reader.Read()
 
-		if line == "" {
-			continue
+		if errRead == io.EOF {
+			return
 		}
 
-		chanCsvItem <- c.csvLineToStruct(line)
-	}
+		if errRead != nil {
+			return fmt.Errorf("Failed to read the csv file: %v", errRead)
+		}
 
-	if closeChan {
-		close(chanCsvItem)
-	}
+		if isCsvHeader(record) {
+			continue
+		}
 
-	err = scanner.Err()
+		err = fn(recordToCsvItem(record))
 
-	if err != nil {
-		return fmt.Errorf("Failed to read the csv file: %v", err)
+		if err != nil {
+			return
+		}
 	}
-
-	return
 }
 
+// StartNew creates the temporary file, which replaces the input file once it is complete.
 func (c *Csv) StartNew() (err error) {
 	fileTempPath := c.FileInput.Path + ".tmp"
 	c.FileTemp, err = NewCsvFile(fileTempPath, true)
 
+	if err == nil {
+		err = c.FileTemp.insertRecord([]string{CsvVersionLine})
+	}
+
+	if err == nil {
+		err = c.FileTemp.insertRecord(CsvHeader)
+	}
+
 	if err != nil {
 		err = fmt.Errorf("Failed to create temporary csv file: %v", err)
 	}
@@ -138,7 +165,11 @@ func (c *Csv) StartNew() (err error) {
 }
 
 func (cf *CsvFile) Insert(item CsvItem) (err error) {
-	cntWritten, err := cf.Resource.WriteString(item.toCsv() + "\n")
+	return cf.insertRecord(item.toRecord())
+}
+
+func (cf *CsvFile) insertRecord(record []string) (err error) {
+	cntWritten, err := cf.Resource.WriteString(recordToCsv(record) + "\n")
 
 	if err != nil {
 		return fmt.Errorf("Failed to write to the temporary csv file: %v", err)
@@ -149,54 +180,135 @@ func (cf *CsvFile) Insert(item CsvItem) (err error) {
 	return nil
 }
 
+// Migrate rewrites the file into the current format, returns false if it was already in it.
+func (c *Csv) Migrate() (migrated bool, err error) {
+	if c.version == CsvVersion {
+		return
+	}
+
+	err = c.StartNew()
+
+	if err != nil {
+		return
+	}
+
+	err = c.ForEach(c.FileTemp.Insert)
+
+	if err == nil {
+		err = c.Replace()
+	}
+
+	return err == nil, err
+}
+
 func (c *Csv) analyze() (cntValid int, cntInvalid int, err error) {
 	re := regexp.MustCompile(HashRegexp)
-	scanner := bufio.NewScanner(c.FileInput.Resource)
+	reader := newCsvReader(c.FileInput.Resource)
+
+	for {
+		record, errRead := reader.Read()
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+		if errRead == io.EOF {
+			break
+		}
+
+		var errParse *csv.ParseError
+
+		if errors.As(errRead, &errParse) {
+			c.out.Log("Invalid line: " + errParse.Error())
+			cntInvalid++
 
-		if line == "" {
 			continue
 		}
 
-		parts := strings.Split(line, CsvSeparator)
+		if errRead != nil {
+			err = fmt.Errorf("error reading file <%s>: %s", c.FileInput.Path, errRead)
 
-		if len(parts) == CntCsvFileParts {
-			_, err = strconv.Atoi(parts[4])
+			return
+		}
+
+		if version, ok := csvVersion(record); ok {
+			if version != CsvVersion {
+				err = fmt.Errorf("file <%s> is in an unknown format %s", c.FileInput.Path, version)
 
-			if err == nil && re.MatchString(parts[0]) && re.MatchString(parts[1]) {
-				cntValid++
-				continue
+				return
 			}
+
+			c.version = version
+
+			continue
+		}
+
+		if isCsvHeader(record) {
+			continue
+		}
+
+		if isValidCsvRecord(record, re) {
+			cntValid++
+
+			continue
 		}
 
-		c.out.Log("Invalid line: " + line)
+		c.out.Log("Invalid line: " + recordToCsv(record))
 		cntInvalid++
 	}
 
-	err = scanner.Err()
+	return
+}
 
-	if err != nil {
-		err = fmt.Errorf("error reading file <%s>: %s", c.FileInput.Path, err)
+func isValidCsvRecord(record []string, re *regexp.Regexp) bool {
+	if len(record) != CntCsvFileParts && len(record) != CntCsvFilePartsLegacy {
+		return false
+	}
+
+	_, err := strconv.Atoi(record[4])
+	isNsec := record[0] == "" && record[1] == "" && record[5] != "" // cleartext name from NSEC walk
+
+	return err == nil && (isNsec || re.MatchString(record[0]) && re.MatchString(record[1]))
+}
+
+// isCsvHeader is true for the version line and the header row
+func isCsvHeader(record []string) bool {
+	_, isVersion := csvVersion(record)
+
+	return isVersion || len(record) > 0 && record[0] == CsvHeader[0]
+}
+
+// csvVersion returns the version of the version line, ok is false for other rows
+func csvVersion(record []string) (version string, ok bool) {
+	if len(record) != 1 || !strings.HasPrefix(record[0], CsvVersionPrefix+" ") {
+		return "", false
 	}
 
+	return strings.TrimPrefix(record[0], CsvVersionPrefix+" "), true
+}
+
+func newCsvReader(reader io.Reader) (csvReader *csv.Reader) {
+	csvReader = csv.NewReader(reader)
+	csvReader.Comma = []rune(CsvSeparator)[0]
+	csvReader.FieldsPerRecord = -1 // legacy rows have fewer columns
+
 	return
 }
 
-func (c *Csv) csvLineToStruct(line string) CsvItem {
-	parts := strings.Split(line, CsvSeparator)
-	iterInt, _ := strconv.Atoi(parts[4])
+func recordToCsvItem(record []string) CsvItem {
+	iterInt, _ := strconv.Atoi(record[4])
 
-	return CsvItem{
-		Hash:       parts[0],
-		HashNext:   parts[1],
-		Domain:     parts[2],
-		Salt:       parts[3],
+	item := CsvItem{
+		Hash:       record[0],
+		HashNext:   record[1],
+		Domain:     record[2],
+		Salt:       record[3],
 		Iterations: iterInt,
-		Plaintext:  parts[5],
-		Types:      strings.Split(parts[6], ","),
+		Plaintext:  record[5],
+		Types:      strings.Split(record[6], CsvTypesSeparator),
+	}
+
+	if len(record) > CntCsvFilePartsLegacy {
+		item.Validation = record[7]
 	}
+
+	return item
 }
 
 func (c *Csv) Replace() (err error) {
@@ -216,16 +328,69 @@ func (c *Csv) Replace() (err error) {
 	return
 }
 
-func (cl CsvItem) toCsv() string {
-	items := []string{
+func (cl CsvItem) toRecord() []string {
+	return []string{
 		cl.Hash,
 		cl.HashNext,
 		cl.Domain,
 		cl.Salt,
 		strconv.Itoa(cl.Iterations),
 		cl.Plaintext,
-		strings.Join(cl.Types, "|"),
+		strings.Join(cl.Types, CsvTypesSeparator),
+		cl.Validation,
+	}
+}
+
+func (cl CsvItem) toCsv() string {
+	return recordToCsv(cl.toRecord())
+}
+
+// recordToCsv returns a CSV line without the line break, quoted where needed.
+func recordToCsv(record []string) string {
+	line := bytes.Buffer{}
+	writer := csv.NewWriter(&line)
+	writer.Comma = []rune(CsvSeparator)[0]
+	_ = writer.Write(record) // writing into a buffer doesn't fail
+	writer.Flush()
+
+	return strings.TrimSuffix(line.String(), "\n")
+}
+
+// checkCsvAppendable returns an error if the existing CSV file is not in the current format,
+// rows of a resumed walk would be appended to it in a different one.
+func checkCsvAppendable(filePath string) (err error) {
+	file, err := os.Open(filePath)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	defer file.Close()
+
+	record, err := newCsvReader(file).Read()
+
+	if err == io.EOF {
+		return nil // the header is written into an empty file
+	}
+
+	if version, _ := csvVersion(record); err == nil && version != CsvVersion {
+		err = fmt.Errorf("Can't resume, %s is in an older format. Upgrade it with --%s first", filePath, FlagMigrateCsv)
+	}
+
+	return
+}
+
+// writeCsvHeader writes the version line and the header into a new or empty CSV file.
+func writeCsvHeader(file *File) (err error) {
+	info, err := file.Pointer.Stat()
+
+	if err == nil && info.Size() == 0 {
+		err = file.Write(CsvVersionLine + "\n" + recordToCsv(CsvHeader) + "\n")
 	}
 
-	return strings.Join(items, CsvSeparator)
+	return
 }
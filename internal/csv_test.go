@@ -0,0 +1,108 @@
+package nsec3walker
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCsvForEachStops checks the error of the callback stops the reading and is returned
+func TestCsvForEachStops(t *testing.T) {
+	h := testChain(3)
+	filePath := testCsvFile(t, testCsvRow(h[0], h[1]), testCsvRow(h[1], h[2]), testCsvRow(h[2], h[0]))
+	config := NewWalkConfig("")
+	config.Output.SetLogger(func(string) {})
+
+	csv, err := NewCsv(filePath, config.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer csv.FileInput.Resource.Close()
+
+	errStop := errors.New("stop")
+	var hashes []string
+
+	err = csv.ForEach(func(csvItem CsvItem) error {
+		hashes = append(hashes, csvItem.Hash)
+
+		if len(hashes) == 2 {
+			return errStop
+		}
+
+		return nil
+	})
+
+	if !errors.Is(err, errStop) || len(hashes) != 2 || hashes[0] != h[0] || hashes[1] != h[1] {
+		t.Fatalf("read %v with error %v, want the first 2 rows and the error of the callback", hashes, err)
+	}
+}
+
+// TestCheckCsvAppendable checks resuming is refused onto a CSV file without the header
+func TestCheckCsvAppendable(t *testing.T) {
+	h := testChain(2)
+	filePath := testCsvFile(t, testCsvRow(h[0], h[1]))
+
+	if err := checkCsvAppendable(filePath); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkCsvAppendable(filePath + ".missing"); err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := filepath.Join(t.TempDir(), "legacy"+SuffixCsv)
+	line := strings.Join([]string{h[0], h[1], "example.com", "aabb", "1", "", ""}, CsvSeparator) + "\n"
+
+	if err := os.WriteFile(legacy, []byte(line), PermFile); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkCsvAppendable(legacy); err == nil {
+		t.Fatal("resuming onto a headerless CSV file is allowed")
+	}
+}
+
+// TestCsvMigrate checks a headerless file gets the version line and the header, rows keep their columns
+func TestCsvMigrate(t *testing.T) {
+	h := testChain(2)
+	filePath := filepath.Join(t.TempDir(), "legacy"+SuffixCsv)
+	line := strings.Join([]string{h[0], h[1], "example.com", "aabb", "1", "", "A|NS"}, CsvSeparator) + "\n"
+	config := NewWalkConfig("")
+	config.Output.SetLogger(func(string) {})
+
+	if err := os.WriteFile(filePath, []byte(line), PermFile); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, wantMigrated := range []bool{true, false} {
+		csv, err := NewCsv(filePath, config.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		migrated, err := csv.Migrate()
+		_ = csv.FileInput.Resource.Close()
+
+		if err != nil || migrated != wantMigrated {
+			t.Fatalf("run %d migrated %v with error %v, want %v", i, migrated, err, wantMigrated)
+		}
+	}
+
+	content, _ := os.ReadFile(filePath)
+	want := CsvVersionLine + "\n" + recordToCsv(CsvHeader) + "\n" + strings.TrimSuffix(line, "\n") + CsvSeparator + "\n"
+
+	if string(content) != want {
+		t.Fatalf("migrated into\n%s, want\n%s", content, want)
+	}
+
+	if err := os.WriteFile(filePath, []byte(CsvVersionPrefix+" v9\n"+line), PermFile); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewCsv(filePath, config.Output); err == nil {
+		t.Fatal("file of an unknown version is read")
+	}
+}
@@ -43,13 +43,10 @@ func (cu *CsvUpdate) Run() (err error) {
 		return
 	}
 
-	chanCsvItem := make(chan CsvItem, 10)
-	go cu.csvToChan(chanCsvItem)
-
-	for csvItem := range chanCsvItem {
-		plaintext, ok, errNow := cu.Cracked.GetForCsvItem(csvItem)
-		if errNow != nil {
-			return errNow
+	err = cu.Csv.ForEach(func(csvItem CsvItem) (err error) {
+		plaintext, ok, err := cu.Cracked.GetForCsvItem(csvItem)
+		if err != nil {
+			return
 		}
 
 		if ok && plaintext != csvItem.Plaintext {
@@ -57,21 +54,13 @@ func (cu *CsvUpdate) Run() (err error) {
 			cu.cntChanged++
 		}
 
-		err = cu.Csv.FileTemp.Insert(csvItem)
-		if err != nil {
-			return
-		}
+		return cu.Csv.FileTemp.Insert(csvItem)
+	})
+	if err != nil {
+		return
 	}
 
 	err = cu.Csv.Replace()
 
 	return
 }
-
-func (cu *CsvUpdate) csvToChan(chanCsvItem chan CsvItem) {
-	err := cu.Csv.ReadToChan(chanCsvItem, true)
-
-	if err != nil {
-		cu.cnf.Output.Log(err.Error())
-	}
-}
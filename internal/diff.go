@@ -0,0 +1,308 @@
+package nsec3walker
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+const (
+	DiffAdded    = "added"
+	DiffRemoved  = "removed"
+	DiffChanged  = "changed"
+	DiffKindHash = "hash"
+	DiffKindName = "name"
+)
+
+// Diff compares two walks of the same zone, old and new CSV file.
+type Diff struct {
+	cnf     *Config
+	out     *Output
+	old     *diffSide
+	new     *diffSide
+	cnt     map[string]int // "kind change" => count
+	onEntry func(DiffEntry)
+}
+
+// DiffEntry is one difference between the walks. Types are set for changed names only.
+type DiffEntry struct {
+	Domain   string
+	Change   string
+	Kind     string
+	Hash     string
+	Name     string
+	OldTypes []string
+	NewTypes []string
+}
+
+// diffSide is everything known from one CSV file
+type diffSide struct {
+	filePath string
+	nsec     Nsec3Params
+	hashes   map[string][]string // hash => types, nil for hashes seen only as an end of a range
+	cracked  *Cracked
+	names    map[string][]string // cleartext names from a NSEC walk => types
+}
+
+func NewDiff(config *Config, oldPath string, newPath string) (diff *Diff) {
+	diff = &Diff{
+		cnf: config,
+		out: config.Output,
+		old: newDiffSide(oldPath),
+		new: newDiffSide(newPath),
+		cnt: make(map[string]int),
+	}
+
+	diff.onEntry = diff.print
+
+	return
+}
+
+func newDiffSide(filePath string) *diffSide {
+	return &diffSide{
+		filePath: filePath,
+		hashes:   make(map[string][]string),
+		cracked:  NewCracked(),
+		names:    make(map[string][]string),
+	}
+}
+
+func (d *Diff) Run() (err error) {
+	for _, side := range []*diffSide{d.old, d.new} {
+		err = side.load(d.out)
+
+		if err != nil {
+			return
+		}
+	}
+
+	if d.old.domain() != d.new.domain() {
+		return fmt.Errorf("can't diff walks of different zones, [%s] and [%s]", d.old.domain(), d.new.domain())
+	}
+
+	if d.old.nsec.key == d.new.nsec.key {
+		d.diffHashes()
+	} else {
+		msg := "NSEC3 params differ, salt [%s] and [%d] iterations vs [%s] and [%d], comparing names only"
+		d.out.Logf(msg, d.old.nsec.saltString, d.old.nsec.iterations, d.new.nsec.saltString, d.new.nsec.iterations)
+	}
+
+	d.diffNames()
+
+	msg := "Diff: %d hashes added, %d removed, %d names added, %d removed, %d with changed types"
+	d.out.Logf(msg,
+		d.cnt[DiffKindHash+DiffAdded], d.cnt[DiffKindHash+DiffRemoved],
+		d.cnt[DiffKindName+DiffAdded], d.cnt[DiffKindName+DiffRemoved], d.cnt[DiffKindName+DiffChanged],
+	)
+
+	return
+}
+
+func (d *Diff) diffHashes() {
+	for _, hash := range slices.Sorted(maps.Keys(d.new.hashes)) {
+		if _, ok := d.old.hashes[hash]; !ok {
+			d.emit(DiffEntry{Change: DiffAdded, Kind: DiffKindHash, Hash: hash})
+		}
+	}
+
+	for _, hash := range slices.Sorted(maps.Keys(d.old.hashes)) {
+		if _, ok := d.new.hashes[hash]; !ok {
+			d.emit(DiffEntry{Change: DiffRemoved, Kind: DiffKindHash, Hash: hash})
+		}
+	}
+}
+
+// diffNames compares names known on either side, a name cracked only on one side
+// is looked up on the other one by its hash, so it works across salt changes too.
+func (d *Diff) diffNames() {
+	names := d.old.knownNames()
+	maps.Copy(names, d.new.knownNames())
+
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		oldTypes, inOld := d.old.lookup(name)
+		newTypes, inNew := d.new.lookup(name)
+		entry := DiffEntry{Kind: DiffKindName, Name: name}
+
+		switch {
+		case inNew && !inOld:
+			entry.Change = DiffAdded
+		case inOld && !inNew:
+			entry.Change = DiffRemoved
+		case inOld && len(oldTypes) > 0 && len(newTypes) > 0 && !slices.Equal(oldTypes, newTypes):
+			entry.Change = DiffChanged
+			entry.OldTypes = oldTypes
+			entry.NewTypes = newTypes
+		default:
+			continue
+		}
+
+		entry.Hash = d.new.hashOf(name)
+
+		if entry.Change == DiffRemoved {
+			entry.Hash = d.old.hashOf(name)
+		}
+
+		d.emit(entry)
+	}
+}
+
+// carriedNames returns names cracked in the old walk, which are in the new one without a plaintext.
+func (d *Diff) carriedNames() (cracked *Cracked) {
+	cracked = NewCracked()
+
+	if d.new.nsec.key == "" {
+		return
+	}
+
+	for name := range d.old.knownNames() {
+		hash := d.new.hashOf(name)
+
+		if _, exists := d.new.hashes[hash]; !exists {
+			continue
+		}
+
+		if _, known := d.new.cracked.Get(d.new.nsec, hash); !known {
+			cracked.Add(d.new.nsec, hash, d.new.namePrefix(name))
+		}
+	}
+
+	return
+}
+
+func (d *Diff) emit(entry DiffEntry) {
+	entry.Domain = d.new.domain()
+	d.cnt[entry.Kind+entry.Change]++
+	d.onEntry(entry)
+}
+
+func (d *Diff) print(entry DiffEntry) {
+	if d.cnf.jsonlSink != nil {
+		d.out.check(d.cnf.jsonlSink.Diff(entry))
+	}
+
+	if d.cnf.Jsonl != JsonlStdout {
+		fmt.Println(entry.toText())
+	}
+}
+
+func (de DiffEntry) toText() string {
+	sign := map[string]string{DiffAdded: "+", DiffRemoved: "-", DiffChanged: "~"}[de.Change]
+
+	if de.Kind == DiffKindHash {
+		return sign + " hash " + de.Hash
+	}
+
+	if de.Change == DiffChanged {
+		oldTypes := strings.Join(de.OldTypes, CsvTypesSeparator)
+		newTypes := strings.Join(de.NewTypes, CsvTypesSeparator)
+
+		return fmt.Sprintf("%s name %s %s -> %s", sign, de.Name, oldTypes, newTypes)
+	}
+
+	return sign + " name " + de.Name
+}
+
+func (ds *diffSide) load(out *Output) (err error) {
+	csv, err := NewCsv(ds.filePath, out)
+
+	if err != nil {
+		return
+	}
+
+	defer csv.FileInput.Resource.Close()
+
+	err = csv.ForEach(ds.add)
+
+	if err != nil {
+		err = fmt.Errorf("can't diff %s: %w", ds.filePath, err)
+	}
+
+	return
+}
+
+func (ds *diffSide) add(csvItem CsvItem) (err error) {
+	types := csvItem.Types
+
+	if len(types) == 1 && types[0] == "" {
+		types = nil
+	}
+
+	if csvItem.Hash == "" {
+		ds.names[normalizeDomain(csvItem.Plaintext)] = types
+		ds.nsec.domain = normalizeDomain(csvItem.Domain)
+
+		return
+	}
+
+	nsec, err := NewNsec3Params(normalizeDomain(csvItem.Domain), csvItem.Salt, csvItem.Iterations)
+
+	if err != nil {
+		return
+	}
+
+	if ds.nsec.key == "" {
+		ds.nsec = nsec
+	} else if ds.nsec.key != nsec.key {
+		return fmt.Errorf("mixed NSEC3 params [%s] and [%s], check it with --%s", ds.nsec.key, nsec.key, FlagVerifyCsv)
+	}
+
+	ds.hashes[csvItem.Hash] = types
+
+	if _, ok := ds.hashes[csvItem.HashNext]; !ok {
+		ds.hashes[csvItem.HashNext] = nil
+	}
+
+	if csvItem.Plaintext != "" {
+		ds.cracked.Add(ds.nsec, csvItem.Hash, ds.namePrefix(normalizeDomain(csvItem.Plaintext)))
+	}
+
+	return
+}
+
+// namePrefix returns the name without the zone, as Cracked keeps it
+func (ds *diffSide) namePrefix(name string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(name, ds.nsec.domain), ".")
+}
+
+func (ds *diffSide) domain() string {
+	return ds.nsec.domain
+}
+
+func (ds *diffSide) knownNames() (names map[string]bool) {
+	names = make(map[string]bool)
+
+	for name := range ds.names {
+		names[name] = true
+	}
+
+	for _, hashes := range ds.cracked.Iterate() {
+		for _, name := range hashes {
+			names[name] = true
+		}
+	}
+
+	return
+}
+
+// hashOf returns the NSEC3 hash of the name, empty for a NSEC walk
+func (ds *diffSide) hashOf(name string) (hash string) {
+	if ds.nsec.key != "" {
+		hash, _ = ds.nsec.CalculateHash(name)
+	}
+
+	return
+}
+
+// lookup returns types of the name, exists is false if the name is not in this walk of the zone
+func (ds *diffSide) lookup(name string) (types []string, exists bool) {
+	types, exists = ds.names[name]
+
+	if exists || ds.nsec.key == "" {
+		return
+	}
+
+	types, exists = ds.hashes[ds.hashOf(name)]
+
+	return
+}
@@ -0,0 +1,763 @@
+package nsec3walker
+
+import (
+	"cmp"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+)
+
+const (
+	DiskTreeCachePages = 32_768 // nodes kept in memory, 128 MB of pages
+	SuffixIndex        = ".index"
+	diskPageSize       = 4096
+	diskTreeMagic      = "NSEC3IDX"
+	diskTreeVersion    = 1
+	diskRangeSize      = 2*HashSize + 1
+	diskLeafHeader     = 24 // type, count, previous and next leaf
+	diskInnerHeader    = 8  // type, count
+	diskLeafOrder      = (diskPageSize - diskLeafHeader) / diskRangeSize
+	diskInnerOrder     = (diskPageSize - diskInnerHeader + HashSize) / (8 + HashSize)
+	diskPageLeaf       = 1
+	diskPageInner      = 2
+	diskMetaClean      = 56
+	diskMetaKey        = 58
+)
+
+var errIndexLocked = errors.New("index file is used by another walk")
+
+// DiskTree is HashTree in a file of 4 KB pages, for chains which don't fit in memory. Page 0 holds the meta data
+// and the other ones are nodes, 0 is no page. Recently used nodes are cached and written back once evicted,
+// in batches ordered by their pages. The file is marked clean only by Close, the index of an interrupted process
+// is not trusted. Pages of dropped nodes are not reused, as removals are rare. It is synchronized on its own,
+// as even lookups load pages into the cache.
+type DiskTree struct {
+	file  *os.File
+	path  string
+	key   string // Nsec3Params.key of the chain, an index of other params is not used
+	root  uint64
+	first uint64 // leftmost leaf
+	last  uint64 // rightmost leaf
+	pages uint64
+	size  int
+	cache int // max cached nodes
+	nodes map[uint64]*diskNode
+	lru   *list.List // front is the most recently used
+	page  [diskPageSize]byte
+	err   error
+	mutex sync.Mutex
+}
+
+type diskNode struct {
+	id       uint64
+	isLeaf   bool
+	isDirty  bool
+	ranges   []hashRange  // leaf only, sorted by start
+	keys     []HashDigest // inner only, keys[i] is lower bound of children[i+1]
+	children []uint64
+	prev     uint64 // leaves only
+	next     uint64
+	elem     *list.Element
+}
+
+// diskPos points to a range in a leaf, zero value is no range. It is valid only until the lock is released.
+type diskPos struct {
+	dt   *DiskTree
+	leaf *diskNode
+	i    int
+}
+
+// OpenDiskTree opens the index file of the chain. With keep the ranges of a previous walk are kept, if it closed
+// the index cleanly, isRestored tells they were. Otherwise the index starts empty.
+// The file is locked, so another walk can't open it, until it is closed.
+func OpenDiskTree(path string, key string, keep bool) (dt *DiskTree, isRestored bool, err error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, PermFile)
+
+	if err != nil {
+		return
+	}
+
+	if err = lockFile(file); err != nil {
+		_ = file.Close()
+
+		return nil, false, fmt.Errorf("%s: %w", path, err)
+	}
+
+	dt = &DiskTree{
+		file:  file,
+		path:  path,
+		key:   key,
+		cache: DiskTreeCachePages,
+		nodes: make(map[uint64]*diskNode),
+		lru:   list.New(),
+	}
+
+	if keep {
+		isRestored = dt.readMeta() == nil
+	}
+
+	if !isRestored {
+		err = dt.reset()
+	}
+
+	if err == nil {
+		err = dt.writeMeta(false) // stays unclean until Close
+	}
+
+	if err != nil {
+		_ = file.Close()
+
+		return nil, false, err
+	}
+
+	return
+}
+
+func (dt *DiskTree) readMeta() (err error) {
+	page := dt.page[:]
+	_, err = dt.file.ReadAt(page, 0)
+
+	if err != nil {
+		return
+	}
+
+	le := binary.LittleEndian
+	keyEnd := diskMetaKey + 2 + int(le.Uint16(page[diskMetaKey:]))
+
+	switch {
+	case string(page[:len(diskTreeMagic)]) != diskTreeMagic || le.Uint32(page[8:]) != diskTreeVersion:
+		return fmt.Errorf("%s is not an index file", dt.path)
+	case page[diskMetaClean] != 1:
+		return fmt.Errorf("%s was not closed cleanly", dt.path)
+	case keyEnd > diskPageSize || string(page[diskMetaKey+2:keyEnd]) != dt.key:
+		return fmt.Errorf("%s is an index of another chain", dt.path)
+	}
+
+	dt.root, dt.first, dt.last = le.Uint64(page[16:]), le.Uint64(page[24:]), le.Uint64(page[32:])
+	dt.pages, dt.size = le.Uint64(page[40:]), int(le.Uint64(page[48:]))
+
+	return
+}
+
+func (dt *DiskTree) writeMeta(isClean bool) (err error) {
+	le := binary.LittleEndian
+	page := dt.page[:]
+	clear(page)
+	copy(page, diskTreeMagic)
+	le.PutUint32(page[8:], diskTreeVersion)
+	le.PutUint32(page[12:], diskPageSize)
+
+	for i, value := range []uint64{dt.root, dt.first, dt.last, dt.pages, uint64(dt.size)} {
+		le.PutUint64(page[16+8*i:], value)
+	}
+
+	if isClean {
+		page[diskMetaClean] = 1
+	}
+
+	le.PutUint16(page[diskMetaKey:], uint16(len(dt.key)))
+	copy(page[diskMetaKey+2:], dt.key)
+
+	_, err = dt.file.WriteAt(page, 0)
+
+	if err == nil {
+		err = dt.file.Sync()
+	}
+
+	return
+}
+
+// reset empties the file and starts with an empty root leaf
+func (dt *DiskTree) reset() (err error) {
+	err = dt.file.Truncate(0)
+
+	if err != nil {
+		return
+	}
+
+	dt.pages, dt.size = 1, 0
+	clear(dt.nodes)
+	dt.lru.Init()
+
+	leaf := dt.newNode(true)
+	dt.root, dt.first, dt.last = leaf.id, leaf.id, leaf.id
+
+	return
+}
+
+func (dt *DiskTree) newNode(isLeaf bool) (node *diskNode) {
+	node = &diskNode{id: dt.pages, isLeaf: isLeaf, isDirty: true}
+	dt.pages++
+
+	if isLeaf {
+		node.ranges = make([]hashRange, 0, diskLeafOrder+1)
+	} else {
+		node.keys = make([]HashDigest, 0, diskInnerOrder)
+		node.children = make([]uint64, 0, diskInnerOrder+1)
+	}
+
+	node.elem = dt.lru.PushFront(node)
+	dt.nodes[node.id] = node
+
+	return
+}
+
+// node returns the cached node or loads it from its page. When the page can't be read, the error is kept
+// and an empty leaf is returned, so the operation ends as if the tree was empty there.
+func (dt *DiskTree) node(id uint64) *diskNode {
+	if node, exists := dt.nodes[id]; exists {
+		dt.lru.MoveToFront(node.elem)
+
+		return node
+	}
+
+	node := &diskNode{id: id}
+	_, err := dt.file.ReadAt(dt.page[:], int64(id)*diskPageSize)
+
+	if err == nil {
+		err = node.decode(dt.page[:])
+	}
+
+	if err != nil {
+		dt.fail(fmt.Errorf("reading page %d: %w", id, err))
+
+		return &diskNode{isLeaf: true}
+	}
+
+	node.elem = dt.lru.PushFront(node)
+	dt.nodes[id] = node
+
+	return node
+}
+
+func (dt *DiskTree) write(node *diskNode) {
+	if !node.isDirty || dt.err != nil {
+		return
+	}
+
+	node.encode(dt.page[:])
+	_, err := dt.file.WriteAt(dt.page[:], int64(node.id)*diskPageSize)
+
+	if err != nil {
+		dt.fail(fmt.Errorf("writing page %d: %w", node.id, err))
+	}
+
+	node.isDirty = false
+}
+
+// evict writes back and drops the least recently used eighth of the cache once it is full. It runs only
+// between operations, nodes are referenced by pointers while the tree changes.
+func (dt *DiskTree) evict() {
+	if len(dt.nodes) <= dt.cache {
+		return
+	}
+
+	evicted := make([]*diskNode, 0, max(dt.cache/8, 1))
+
+	for len(evicted) < cap(evicted) {
+		node := dt.lru.Remove(dt.lru.Back()).(*diskNode)
+		delete(dt.nodes, node.id)
+		evicted = append(evicted, node)
+	}
+
+	slices.SortFunc(evicted, func(a, b *diskNode) int {
+		return cmp.Compare(a.id, b.id)
+	})
+
+	for _, node := range evicted {
+		dt.write(node)
+	}
+}
+
+func (dt *DiskTree) fail(err error) {
+	if dt.err == nil {
+		dt.err = fmt.Errorf("%w %s: %w", ErrIndexFailed, dt.path, err)
+	}
+}
+
+func (dt *DiskTree) lock() {
+	dt.mutex.Lock()
+}
+
+func (dt *DiskTree) unlock() {
+	dt.evict()
+	dt.mutex.Unlock()
+}
+
+func (n *diskNode) decode(page []byte) (err error) {
+	le := binary.LittleEndian
+	cnt := int(le.Uint16(page[2:]))
+
+	switch {
+	case page[0] == diskPageLeaf && cnt <= diskLeafOrder:
+		n.isLeaf = true
+		n.prev, n.next = le.Uint64(page[8:]), le.Uint64(page[16:])
+		n.ranges = make([]hashRange, cnt, diskLeafOrder+1)
+
+		for i := range n.ranges {
+			entry := page[diskLeafHeader+i*diskRangeSize:]
+			copy(n.ranges[i].start[:], entry)
+			copy(n.ranges[i].end[:], entry[HashSize:])
+			n.ranges[i].hasEnd = entry[2*HashSize] == 1
+		}
+	case page[0] == diskPageInner && cnt > 0 && cnt <= diskInnerOrder:
+		n.children = make([]uint64, cnt, diskInnerOrder+1)
+		n.keys = make([]HashDigest, cnt-1, diskInnerOrder)
+		keys := page[diskInnerHeader+8*diskInnerOrder:]
+
+		for i := range n.children {
+			n.children[i] = le.Uint64(page[diskInnerHeader+8*i:])
+		}
+
+		for i := range n.keys {
+			copy(n.keys[i][:], keys[i*HashSize:])
+		}
+	default:
+		err = errors.New("not a node")
+	}
+
+	return
+}
+
+func (n *diskNode) encode(page []byte) {
+	le := binary.LittleEndian
+	clear(page)
+
+	if n.isLeaf {
+		page[0] = diskPageLeaf
+		le.PutUint16(page[2:], uint16(len(n.ranges)))
+		le.PutUint64(page[8:], n.prev)
+		le.PutUint64(page[16:], n.next)
+
+		for i, r := range n.ranges {
+			entry := page[diskLeafHeader+i*diskRangeSize:]
+			copy(entry, r.start[:])
+			copy(entry[HashSize:], r.end[:])
+
+			if r.hasEnd {
+				entry[2*HashSize] = 1
+			}
+		}
+
+		return
+	}
+
+	page[0] = diskPageInner
+	le.PutUint16(page[2:], uint16(len(n.children)))
+	keys := page[diskInnerHeader+8*diskInnerOrder:]
+
+	for i, child := range n.children {
+		le.PutUint64(page[diskInnerHeader+8*i:], child)
+	}
+
+	for i, key := range n.keys {
+		copy(keys[i*HashSize:], key[:])
+	}
+}
+
+func (dt *DiskTree) leafFor(hash HashDigest) (node *diskNode) {
+	for node = dt.node(dt.root); !node.isLeaf; {
+		node = dt.node(node.children[searchKeys(node.keys, hash)])
+	}
+
+	return
+}
+
+func (dt *DiskTree) Len() int {
+	dt.lock()
+	defer dt.unlock()
+
+	return dt.size
+}
+
+func (dt *DiskTree) get(hash HashDigest) (r hashRange, ok bool) {
+	dt.lock()
+	defer dt.unlock()
+
+	leaf := dt.leafFor(hash)
+
+	if i, found := searchRanges(leaf.ranges, hash); found {
+		return leaf.ranges[i], true
+	}
+
+	return
+}
+
+// floor returns the range with the largest start equal to or smaller than the hash
+func (dt *DiskTree) floor(hash HashDigest) (hashRange, bool) {
+	dt.lock()
+	defer dt.unlock()
+
+	leaf := dt.leafFor(hash)
+	i, found := searchRanges(leaf.ranges, hash)
+
+	if found {
+		return diskPos{dt, leaf, i}.value()
+	}
+
+	return diskPos{dt, leaf, i}.prev().value()
+}
+
+// lower returns the range with the largest start smaller than the hash
+func (dt *DiskTree) lower(hash HashDigest) (hashRange, bool) {
+	dt.lock()
+	defer dt.unlock()
+
+	leaf := dt.leafFor(hash)
+	i, _ := searchRanges(leaf.ranges, hash)
+
+	return diskPos{dt, leaf, i}.prev().value()
+}
+
+func (dt *DiskTree) minPos() diskPos {
+	return diskPos{dt, dt.node(dt.first), -1}.next()
+}
+
+func (dt *DiskTree) maxPos() diskPos {
+	leaf := dt.node(dt.last)
+
+	return diskPos{dt, leaf, len(leaf.ranges)}.prev()
+}
+
+func (dt *DiskTree) max() (hashRange, bool) {
+	dt.lock()
+	defer dt.unlock()
+
+	return dt.maxPos().value()
+}
+
+// around returns the range at the hash, if it exists, and the ranges before and after it,
+// wrapping around the hash space, as HashTree.around does.
+func (dt *DiskTree) around(hash HashDigest) (nb hashNeighbours) {
+	dt.lock()
+	defer dt.unlock()
+
+	leaf := dt.leafFor(hash)
+	i, found := searchRanges(leaf.ranges, hash)
+	before := diskPos{dt, leaf, i}.prev()
+	after := diskPos{dt, leaf, i - 1}.next()
+
+	if found {
+		nb.at, nb.hasAt = leaf.ranges[i], true
+		after = diskPos{dt, leaf, i}.next()
+	}
+
+	if !before.valid() {
+		before = dt.maxPos()
+	}
+
+	if !after.valid() {
+		after = dt.minPos()
+	}
+
+	var ok bool
+
+	nb.before, ok = before.value()
+	nb.after, _ = after.value()
+	nb.isEmpty = !ok
+
+	return
+}
+
+// higherCyclic returns the range with the smallest start larger than the hash, wrapping around the hash space
+func (dt *DiskTree) higherCyclic(hash HashDigest) (hashRange, bool) {
+	dt.lock()
+	defer dt.unlock()
+
+	leaf := dt.leafFor(hash)
+	i, found := searchRanges(leaf.ranges, hash)
+
+	if !found {
+		i--
+	}
+
+	pos := diskPos{dt, leaf, i}.next()
+
+	if !pos.valid() {
+		pos = dt.minPos()
+	}
+
+	return pos.value()
+}
+
+// all yields ranges in order a leaf at a time, the lock is not held while they are yielded
+func (dt *DiskTree) all() iter.Seq[hashRange] {
+	return func(yield func(hashRange) bool) {
+		var ranges []hashRange
+
+		dt.lock()
+		id := dt.first
+		dt.unlock()
+
+		for id != 0 {
+			dt.lock()
+			leaf := dt.node(id)
+			ranges = append(ranges[:0], leaf.ranges...)
+			id = leaf.next
+			dt.unlock()
+
+			for _, r := range ranges {
+				if !yield(r) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// put adds the range or replaces the one with the same start
+func (dt *DiskTree) put(r hashRange) (isNew bool) {
+	dt.lock()
+	defer dt.unlock()
+
+	isNew, split, splitKey := dt.insert(dt.node(dt.root), r)
+
+	if split != nil {
+		root := dt.newNode(false)
+		root.keys = append(root.keys, splitKey)
+		root.children = append(root.children, dt.root, split.id)
+		dt.root = root.id
+	}
+
+	if isNew {
+		dt.size++
+	}
+
+	return
+}
+
+// insert returns the new right sibling and its lower bound when the node had to be split
+func (dt *DiskTree) insert(node *diskNode, r hashRange) (isNew bool, split *diskNode, splitKey HashDigest) {
+	if node.isLeaf {
+		i, found := searchRanges(node.ranges, r.start)
+		node.isDirty = true
+
+		if found {
+			node.ranges[i] = r
+
+			return
+		}
+
+		node.ranges = slices.Insert(node.ranges, i, r)
+
+		if len(node.ranges) > diskLeafOrder {
+			split = dt.splitLeaf(node)
+			splitKey = split.ranges[0].start
+		}
+
+		return true, split, splitKey
+	}
+
+	i := searchKeys(node.keys, r.start)
+	isNew, childSplit, childKey := dt.insert(dt.node(node.children[i]), r)
+
+	if childSplit == nil {
+		return
+	}
+
+	node.isDirty = true
+	node.keys = slices.Insert(node.keys, i, childKey)
+	node.children = slices.Insert(node.children, i+1, childSplit.id)
+
+	if len(node.children) > diskInnerOrder {
+		split, splitKey = dt.splitInner(node)
+	}
+
+	return
+}
+
+func (dt *DiskTree) splitLeaf(leaf *diskNode) (right *diskNode) {
+	half := len(leaf.ranges) / 2
+	right = dt.newNode(true)
+	right.ranges = append(right.ranges, leaf.ranges[half:]...)
+	leaf.ranges = leaf.ranges[:half]
+
+	right.prev, right.next = leaf.id, leaf.next
+
+	if leaf.next == 0 {
+		dt.last = right.id
+	} else {
+		next := dt.node(leaf.next)
+		next.prev = right.id
+		next.isDirty = true
+	}
+
+	leaf.next = right.id
+
+	return
+}
+
+func (dt *DiskTree) splitInner(node *diskNode) (right *diskNode, splitKey HashDigest) {
+	half := len(node.children) / 2
+	splitKey = node.keys[half-1]
+	right = dt.newNode(false)
+	right.keys = append(right.keys, node.keys[half:]...)
+	right.children = append(right.children, node.children[half:]...)
+
+	node.keys = node.keys[:half-1]
+	node.children = node.children[:half]
+
+	return
+}
+
+func (dt *DiskTree) remove(hash HashDigest) (removed bool) {
+	dt.lock()
+	defer dt.unlock()
+
+	removed, _ = dt.delete(dt.node(dt.root), hash)
+	root := dt.node(dt.root)
+
+	for !root.isLeaf && len(root.children) == 1 {
+		dt.root = root.children[0]
+		root = dt.node(dt.root)
+	}
+
+	if !root.isLeaf && len(root.children) == 0 {
+		leaf := dt.newNode(true)
+		dt.root, dt.first, dt.last = leaf.id, leaf.id, leaf.id
+	}
+
+	if removed {
+		dt.size--
+	}
+
+	return
+}
+
+// delete removes the hash from the subtree, isEmpty tells the parent to drop the node
+func (dt *DiskTree) delete(node *diskNode, hash HashDigest) (removed bool, isEmpty bool) {
+	if node.isLeaf {
+		i, found := searchRanges(node.ranges, hash)
+
+		if !found {
+			return
+		}
+
+		node.ranges = slices.Delete(node.ranges, i, i+1)
+		node.isDirty = true
+		isEmpty = len(node.ranges) == 0 && node.id != dt.root
+
+		if isEmpty {
+			dt.unlink(node)
+		}
+
+		return true, isEmpty
+	}
+
+	i := searchKeys(node.keys, hash)
+	removed, isChildEmpty := dt.delete(dt.node(node.children[i]), hash)
+
+	if isChildEmpty {
+		node.isDirty = true
+		node.children = slices.Delete(node.children, i, i+1)
+
+		if i > 0 {
+			node.keys = slices.Delete(node.keys, i-1, i)
+		} else if len(node.keys) > 0 {
+			node.keys = slices.Delete(node.keys, 0, 1)
+		}
+	}
+
+	return removed, len(node.children) == 0 && node.id != dt.root
+}
+
+func (dt *DiskTree) unlink(leaf *diskNode) {
+	if leaf.prev == 0 {
+		dt.first = leaf.next
+	} else {
+		prev := dt.node(leaf.prev)
+		prev.next = leaf.next
+		prev.isDirty = true
+	}
+
+	if leaf.next == 0 {
+		dt.last = leaf.prev
+	} else {
+		next := dt.node(leaf.next)
+		next.prev = leaf.prev
+		next.isDirty = true
+	}
+}
+
+// Err returns the first failure of reading or writing the file
+func (dt *DiskTree) Err() error {
+	dt.lock()
+	defer dt.unlock()
+
+	return dt.err
+}
+
+// Close writes out cached nodes and marks the file clean, if nothing failed. It can be called more than once.
+func (dt *DiskTree) Close() (err error) {
+	dt.mutex.Lock()
+	defer dt.mutex.Unlock()
+
+	if dt.file == nil {
+		return dt.err
+	}
+
+	for _, id := range slices.Sorted(maps.Keys(dt.nodes)) {
+		dt.write(dt.nodes[id])
+	}
+
+	if dt.err == nil {
+		if err = dt.writeMeta(true); err != nil {
+			dt.fail(err)
+		}
+	}
+
+	err = dt.file.Close()
+	dt.file = nil
+
+	return cmp.Or(dt.err, err)
+}
+
+func (pos diskPos) valid() bool {
+	return pos.leaf != nil && pos.i >= 0 && pos.i < len(pos.leaf.ranges)
+}
+
+// value returns the range, ok is false if the position is not valid
+func (pos diskPos) value() (r hashRange, ok bool) {
+	if pos.valid() {
+		r, ok = pos.leaf.ranges[pos.i], true
+	}
+
+	return
+}
+
+func (pos diskPos) next() diskPos {
+	for leaf, i := pos.leaf, pos.i+1; leaf != nil; leaf, i = pos.dt.node(leaf.next), 0 {
+		if i < len(leaf.ranges) {
+			return diskPos{pos.dt, leaf, i}
+		}
+
+		if leaf.next == 0 {
+			break
+		}
+	}
+
+	return diskPos{}
+}
+
+func (pos diskPos) prev() diskPos {
+	for leaf, i := pos.leaf, pos.i-1; leaf != nil; {
+		if i >= 0 {
+			return diskPos{pos.dt, leaf, i}
+		}
+
+		if leaf.prev == 0 {
+			break
+		}
+
+		leaf = pos.dt.node(leaf.prev)
+		i = len(leaf.ranges) - 1
+	}
+
+	return diskPos{}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package nsec3walker
+
+import "os"
+
+// lockFile is a no-op where flock is not available, the index file is not protected from a second walk.
+func lockFile(_ *os.File) error {
+	return nil
+}
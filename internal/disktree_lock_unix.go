@@ -0,0 +1,20 @@
+//go:build unix
+
+package nsec3walker
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock of the index file, it is released when the file is closed.
+func lockFile(file *os.File) (err error) {
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		err = errIndexLocked
+	}
+
+	return
+}
@@ -0,0 +1,36 @@
+//go:build unix
+
+package nsec3walker
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskTreeLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "t"+SuffixIndex)
+	dt, _ := testOpenDiskTree(t, path, false)
+	testStore(dt, 100)
+
+	_, _, err := OpenDiskTree(path, testIndexKey, true)
+
+	if !errors.Is(err, errIndexLocked) {
+		t.Fatalf("second open of the index got %v, want %v", err, errIndexLocked)
+	}
+
+	if dt.Len() != 100 {
+		t.Fatalf("index has %d ranges after the second open", dt.Len())
+	}
+
+	if err = dt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dt, isRestored := testOpenDiskTree(t, path, true)
+	defer dt.Close()
+
+	if !isRestored {
+		t.Fatal("index is not restored once the first walk closed it")
+	}
+}
@@ -0,0 +1,223 @@
+package nsec3walker
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const testIndexKey = "example.com|aabb|10"
+
+// testDigest returns the i-th of cnt hashes spread evenly over the hash space
+func testDigest(i int, cnt int) (hash HashDigest) {
+	binary.BigEndian.PutUint64(hash[:], uint64(i)*(math.MaxUint64/uint64(cnt)))
+	hash[HashSize-1] = byte(i) // not only the prefix differs
+
+	return
+}
+
+// testStore fills the store with ranges of a complete chain of cnt hashes, in a scattered order
+func testStore(store rangeStore, cnt int) {
+	stride := benchmarkStride(cnt)
+
+	for i := 0; i < cnt; i++ {
+		pos := i * stride % cnt
+		store.put(hashRange{start: testDigest(pos, cnt), end: testDigest((pos+1)%cnt, cnt), hasEnd: true})
+	}
+}
+
+func testOpenDiskTree(t *testing.T, path string, keep bool) (dt *DiskTree, isRestored bool) {
+	t.Helper()
+
+	dt, isRestored, err := OpenDiskTree(path, testIndexKey, keep)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func collectRanges(store rangeStore) (ranges []hashRange) {
+	for r := range store.all() {
+		ranges = append(ranges, r)
+	}
+
+	return
+}
+
+// TestDiskTreeEviction runs the same random operations on DiskTree with a tiny cache and on HashTree
+func TestDiskTreeEviction(t *testing.T) {
+	dt, _ := testOpenDiskTree(t, filepath.Join(t.TempDir(), "t"+SuffixIndex), false)
+	defer dt.Close()
+
+	dt.cache = 8
+	ht := NewHashTree()
+	rnd := rand.New(rand.NewSource(1))
+	cnt := 20_000
+
+	for i := 0; i < 100_000; i++ {
+		hash := testDigest(rnd.Intn(cnt), cnt)
+		r := hashRange{start: hash, end: testDigest(rnd.Intn(cnt), cnt), hasEnd: rnd.Intn(2) == 0}
+
+		if rnd.Intn(4) == 0 {
+			if dt.remove(hash) != ht.remove(hash) {
+				t.Fatalf("remove of %x differs", hash)
+			}
+		} else if dt.put(r) != ht.put(r) {
+			t.Fatalf("put of %x differs", hash)
+		}
+
+		if dt.around(hash) != ht.around(hash) {
+			t.Fatalf("ranges around %x differ", hash)
+		}
+	}
+
+	if err := dt.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if dt.pages <= uint64(dt.cache) {
+		t.Fatalf("%d pages fit into the cache, nothing was evicted", dt.pages)
+	}
+
+	if dt.Len() != ht.Len() || !slices.Equal(collectRanges(dt), collectRanges(ht)) {
+		t.Fatalf("ranges differ, %d on disk and %d in memory", dt.Len(), ht.Len())
+	}
+
+	for i := 0; i < 1000; i++ {
+		hash := testDigest(rnd.Intn(cnt), cnt)
+		hash[HashSize-1]++ // between the known hashes
+
+		got, gotOk := dt.floor(hash)
+		want, wantOk := ht.floor(hash)
+
+		if got != want || gotOk != wantOk {
+			t.Fatalf("floor of %x differs", hash)
+		}
+
+		got, gotOk = dt.higherCyclic(hash)
+		want, wantOk = ht.higherCyclic(hash)
+
+		if got != want || gotOk != wantOk {
+			t.Fatalf("higherCyclic of %x differs", hash)
+		}
+	}
+}
+
+func TestDiskTreeReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "t"+SuffixIndex)
+	cnt := 10_000
+
+	dt, _ := testOpenDiskTree(t, path, false)
+	dt.cache = 16
+	testStore(dt, cnt)
+	want := collectRanges(dt)
+
+	if err := dt.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dt, isRestored := testOpenDiskTree(t, path, true)
+
+	if !isRestored {
+		t.Fatal("index closed cleanly is not restored")
+	}
+
+	if dt.Len() != cnt || !slices.Equal(collectRanges(dt), want) {
+		t.Fatalf("restored %d ranges, want %d", dt.Len(), cnt)
+	}
+
+	_ = dt.Close()
+
+	dt, isRestored = testOpenDiskTree(t, path, false)
+
+	if isRestored || dt.Len() != 0 {
+		t.Fatalf("index opened without keep has %d ranges", dt.Len())
+	}
+
+	_ = dt.Close()
+}
+
+func TestDiskTreeReopenOtherChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "t"+SuffixIndex)
+	dt, _ := testOpenDiskTree(t, path, false)
+	testStore(dt, 100)
+	_ = dt.Close()
+
+	dt, isRestored, err := OpenDiskTree(path, "example.com|ccdd|10", true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer dt.Close()
+
+	if isRestored || dt.Len() != 0 {
+		t.Fatalf("index of another chain is restored with %d ranges", dt.Len())
+	}
+}
+
+// TestDiskTreeUncleanReopen drops the file without Close, as a killed walk does
+func TestDiskTreeUncleanReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "t"+SuffixIndex)
+	dt, _ := testOpenDiskTree(t, path, false)
+	dt.cache = 16
+	testStore(dt, 10_000)
+	_ = dt.file.Close()
+
+	dt, isRestored := testOpenDiskTree(t, path, true)
+	defer dt.Close()
+
+	if isRestored || dt.Len() != 0 {
+		t.Fatalf("index not closed cleanly is restored with %d ranges", dt.Len())
+	}
+}
+
+func TestRangeIndexReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "t"+SuffixIndex)
+	cnt := 1000
+
+	ri, _, err := OpenRangeIndex(path, testIndexKey, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < cnt; i += 2 {
+		_, _, _, err = ri.Add(encodeHash(testDigest(i, cnt)), encodeHash(testDigest(i+1, cnt)))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gaps := ri.Gaps(0)
+
+	if err = ri.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ri, isRestored, err := OpenRangeIndex(path, testIndexKey, true)
+
+	if err != nil || !isRestored {
+		t.Fatalf("index is not restored: %v", err)
+	}
+
+	defer ri.Close()
+
+	if ri.Len() != cnt || ri.isFinished() || len(ri.Gaps(0)) != len(gaps) {
+		t.Fatalf("restored %d hashes and %d gaps, want %d and %d", ri.Len(), len(ri.Gaps(0)), cnt, len(gaps))
+	}
+
+	for i := 1; i < cnt; i += 2 {
+		_, _, _, _ = ri.Add(encodeHash(testDigest(i, cnt)), encodeHash(testDigest((i+1)%cnt, cnt)))
+	}
+
+	if !ri.isFinished() {
+		t.Fatalf("restored chain is not finished, %d gaps left", len(ri.Gaps(0)))
+	}
+}
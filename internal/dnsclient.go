@@ -0,0 +1,104 @@
+package nsec3walker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	EdnsSize      = 4096
+	TransportAuto = "auto"
+	TransportTcp  = "tcp"
+	TransportUdp  = "udp"
+)
+
+// DnsClient queries authoritative servers. In auto mode truncated UDP responses are retried over TCP.
+type DnsClient struct {
+	transport string
+	ednsSize  uint16
+	stats     *Stats
+}
+
+func NewDnsClient(transport string, ednsSize int, stats *Stats) (dc *DnsClient) {
+	dc = &DnsClient{
+		transport: transport,
+		ednsSize:  uint16(ednsSize),
+		stats:     stats,
+	}
+
+	return
+}
+
+func (dc *DnsClient) getNsResponse(domain string, authNsServer string) (r *dns.Msg, err error) {
+	return dc.getDnsResponse(domain, authNsServer, dns.TypeNS)
+}
+
+func (dc *DnsClient) getNsec3ParamResponse(domain string, authNsServer string) (r *dns.NSEC3PARAM, err error) {
+	errNotExists := fmt.Errorf("NSEC3PARAM are not existing")
+	rr, err := dc.getDnsResponse(domain, authNsServer, dns.TypeNSEC3PARAM)
+
+	if err != nil {
+		return
+	}
+
+	if len(rr.Answer) == 0 {
+		return nil, errNotExists
+	}
+
+	nsec3param, ok := rr.Answer[0].(*dns.NSEC3PARAM)
+
+	if !ok {
+		return nil, errNotExists
+	}
+
+	if nsec3param.Hash != dns.SHA1 {
+		return nil, fmt.Errorf("NSEC3 hash is not SHA1")
+	}
+
+	return nsec3param, nil
+}
+
+func (dc *DnsClient) getDnsResponse(domain string, authNsServer string, dnsType uint16) (r *dns.Msg, err error) {
+	m := dns.Msg{}
+	m.SetQuestion(dns.Fqdn(domain), dnsType)
+	m.SetEdns0(dc.ednsSize, true)
+
+	network := TransportUdp
+
+	if dc.transport == TransportTcp {
+		network = TransportTcp
+	}
+
+	r, err = dc.exchange(&m, authNsServer, network)
+
+	if err != nil || !r.Truncated || network == TransportTcp {
+		return
+	}
+
+	dc.stats.truncated.Add(1)
+
+	if dc.transport == TransportUdp {
+		return // what fit in is still usable
+	}
+
+	dc.stats.tcpFallbacks.Add(1)
+	r, err = dc.exchange(&m, authNsServer, TransportTcp)
+
+	return
+}
+
+func (dc *DnsClient) exchange(m *dns.Msg, authNsServer string, network string) (r *dns.Msg, err error) {
+	c := dns.Client{
+		Net:          network,
+		UDPSize:      dc.ednsSize,
+		DialTimeout:  time.Second * 5,
+		ReadTimeout:  time.Second * 10,
+		WriteTimeout: time.Second * 5,
+	}
+
+	r, _, err = c.Exchange(m, authNsServer)
+
+	return
+}
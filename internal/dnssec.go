@@ -0,0 +1,200 @@
+package nsec3walker
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const ValidationValid = "valid"
+
+var (
+	errNoDnskey         = errors.New("no DNSKEY records")
+	errInvalidSignature = errors.New("invalid RRSIG")
+	errDsMismatch       = errors.New("no DNSKEY matches DS records of the parent")
+	errNoDs             = errors.New("no validated DS records in the parent")
+)
+
+// Validator verifies RRSIGs of the collected records against the DNSKEY set of the zone.
+type Validator struct {
+	zone string
+	keys map[uint16][]*dns.DNSKEY // key tags can collide
+}
+
+func NewValidator(zone string, dnskeys []*dns.DNSKEY) (v *Validator) {
+	v = &Validator{
+		zone: dns.Fqdn(strings.ToLower(zone)),
+		keys: make(map[uint16][]*dns.DNSKEY),
+	}
+
+	for _, key := range dnskeys {
+		tag := key.KeyTag()
+		v.keys[tag] = append(v.keys[tag], key)
+	}
+
+	return
+}
+
+// initValidator fetches DNSKEY set of the zone, which has to be signed by a key matching a DS record of the parent.
+func (nw *NSec3Walker) initValidator() (err error) {
+	var dnskeys []*dns.DNSKEY
+	var rrsigs []*dns.RRSIG
+
+	for _, ns := range nw.config.NameServers {
+		dnskeys, rrsigs, err = nw.getDnskeys(ns.Address)
+
+		if err == nil {
+			break
+		}
+
+		nw.out.Logf("Can't get DNSKEY from [%s]: %v", ns, err)
+	}
+
+	if err != nil {
+		return fmt.Errorf("can't validate zone [%s]: %w", nw.nsec.domain, err)
+	}
+
+	anchors, err := nw.getParentAnchors(dnskeys)
+
+	if err != nil {
+		return fmt.Errorf("can't validate zone [%s]: %w", nw.nsec.domain, err)
+	}
+
+	rrset := make([]dns.RR, 0, len(dnskeys))
+
+	for _, key := range dnskeys {
+		rrset = append(rrset, key)
+	}
+
+	if !NewValidator(nw.nsec.domain, anchors).verify(rrset, rrsigs) {
+		return fmt.Errorf("DNSKEY set of [%s] is not signed by a key of the parent DS: %w", nw.nsec.domain, errInvalidSignature)
+	}
+
+	nw.out.Logf("Validating NSEC3 signatures with %d DNSKEY records, %d of them anchored by DS", len(dnskeys), len(anchors))
+	nw.validator = NewValidator(nw.nsec.domain, dnskeys)
+
+	return
+}
+
+func (nw *NSec3Walker) getDnskeys(ns string) (dnskeys []*dns.DNSKEY, rrsigs []*dns.RRSIG, err error) {
+	r, err := nw.client.getDnsResponse(nw.nsec.domain, ns, dns.TypeDNSKEY)
+
+	if err != nil {
+		return
+	}
+
+	for _, rr := range r.Answer {
+		switch record := rr.(type) {
+		case *dns.DNSKEY:
+			dnskeys = append(dnskeys, record)
+		case *dns.RRSIG:
+			rrsigs = append(rrsigs, record)
+		}
+	}
+
+	if len(dnskeys) == 0 {
+		err = errNoDnskey
+	}
+
+	return
+}
+
+// getParentAnchors returns the DNSKEYs matching DS records of the parent. The DS records come from
+// generic resolvers, only answers the resolver validated itself (AD flag) are used.
+func (nw *NSec3Walker) getParentAnchors(dnskeys []*dns.DNSKEY) (anchors []*dns.DNSKEY, err error) {
+	var dss []*dns.DS
+
+	for _, resolver := range nw.config.parseServersValue(nw.config.genericServerInput) {
+		r, errQuery := nw.client.getDnsResponse(nw.nsec.domain, resolver, dns.TypeDS)
+
+		if errQuery != nil {
+			nw.out.LogVerbosef("Can't get DS from [%s]: %v", resolver, errQuery)
+
+			continue
+		}
+
+		if !r.AuthenticatedData {
+			nw.out.LogVerbosef("DS from [%s] is not validated by the resolver", resolver)
+
+			continue
+		}
+
+		for _, rr := range r.Answer {
+			if ds, ok := rr.(*dns.DS); ok {
+				dss = append(dss, ds)
+			}
+		}
+
+		break
+	}
+
+	if len(dss) == 0 {
+		return nil, errNoDs
+	}
+
+	for _, key := range dnskeys {
+		for _, ds := range dss {
+			keyDs := key.ToDS(ds.DigestType)
+
+			if keyDs != nil && keyDs.KeyTag == ds.KeyTag && strings.EqualFold(keyDs.Digest, ds.Digest) {
+				nw.out.Logf("DNSKEY %d matches DS record of the parent", ds.KeyTag)
+				anchors = append(anchors, key)
+
+				break
+			}
+		}
+	}
+
+	if len(anchors) == 0 {
+		err = errDsMismatch
+	}
+
+	return
+}
+
+// verify returns true if any of the RRSIGs covering the RRset is valid now.
+func (v *Validator) verify(rrset []dns.RR, rrsigs []*dns.RRSIG) bool {
+	if len(rrset) == 0 {
+		return false
+	}
+
+	header := rrset[0].Header()
+
+	for _, rrsig := range rrsigs {
+		if rrsig.TypeCovered != header.Rrtype || !strings.EqualFold(rrsig.Header().Name, header.Name) {
+			continue
+		}
+
+		if !strings.EqualFold(rrsig.SignerName, v.zone) || !rrsig.ValidityPeriod(time.Now()) {
+			continue
+		}
+
+		for _, key := range v.keys[rrsig.KeyTag] {
+			if key.Algorithm == rrsig.Algorithm && rrsig.Verify(key, rrset) == nil {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// verifyNsec3 checks the NSEC3 record with the RRSIGs from the same response.
+func (v *Validator) verifyNsec3(nsec3 *dns.NSEC3, rrs []dns.RR) (err error) {
+	var rrsigs []*dns.RRSIG
+
+	for _, rr := range rrs {
+		if rrsig, ok := rr.(*dns.RRSIG); ok {
+			rrsigs = append(rrsigs, rrsig)
+		}
+	}
+
+	if !v.verify([]dns.RR{nsec3}, rrsigs) {
+		err = fmt.Errorf("%w for NSEC3 %s", errInvalidSignature, nsec3.Header().Name)
+	}
+
+	return
+}
@@ -41,7 +41,11 @@ func NewDump(config *Config) (dump *Dump, err error) {
 
 func (d *Dump) Run() (err error) {
 	if d.csv != nil {
-		d.dumpCsv()
+		err = d.dumpCsv()
+	}
+
+	if err != nil {
+		return
 	}
 
 	if d.hashCat != nil {
@@ -51,11 +55,8 @@ func (d *Dump) Run() (err error) {
 	return
 }
 
-func (d *Dump) dumpCsv() {
-	chanCsvItem := make(chan CsvItem, 10)
-	go d.csvToChan(chanCsvItem)
-
-	for csvItem := range chanCsvItem {
+func (d *Dump) dumpCsv() error {
+	return d.csv.ForEach(func(csvItem CsvItem) error {
 		if csvItem.Plaintext != "" {
 			if d.full {
 				fmt.Println(csvItem.Plaintext)
@@ -63,9 +64,9 @@ func (d *Dump) dumpCsv() {
 				fmt.Println(strings.TrimSuffix(csvItem.Plaintext, "."+csvItem.Domain))
 			}
 		}
-	}
 
-	return
+		return nil
+	})
 }
 
 func (d *Dump) dumpHashCat() {
@@ -75,11 +76,3 @@ func (d *Dump) dumpHashCat() {
 		d.hashCat.PrintPlaintextWordlist()
 	}
 }
-
-func (d *Dump) csvToChan(chanCsvItem chan CsvItem) {
-	err := d.csv.ReadToChan(chanCsvItem, true)
-
-	if err != nil {
-		d.cnf.Output.Log(err.Error())
-	}
-}
@@ -6,19 +6,23 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	// TODO increase once I have Context and signal handling
-	BuffSizeHash = 0
-	BuffSizeCsv  = 0
-	PermFile     = 0644
-	PermDir      = 0755
-	SuffixHash   = ".hash"
-	SuffixLog    = ".log"
-	SuffixCsv    = ".csv"
+	BuffSizeHash  = 64
+	BuffSizeCsv   = 64
+	PermFile      = 0644
+	PermDir       = 0755
+	SuffixChanges = ".changes"
+	SuffixHash    = ".hash"
+	SuffixLog     = ".log"
+	SuffixCsv     = ".csv"
+	SuffixEvents  = ".events.jsonl"
+	SuffixLatest  = ".latest"
 )
 
 type File struct {
@@ -26,6 +30,7 @@ type File struct {
 	Pointer    *os.File
 	Writer     *bufio.Writer
 	BuffSizeKb int // BuffSizeKb size in kbytes; 0 for auto-flush
+	mutex      sync.Mutex
 }
 
 func NewFile(name string, buffSizeKb int) (file *File, err error) {
@@ -54,10 +59,13 @@ func NewFile(name string, buffSizeKb int) (file *File, err error) {
 }
 
 func (f *File) Write(data string) (err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
 	_, err = f.Writer.WriteString(data)
 
 	if err == nil && f.BuffSizeKb == 0 {
-		err = f.Flush()
+		err = f.Writer.Flush()
 	}
 
 	if err != nil {
@@ -68,6 +76,9 @@ func (f *File) Write(data string) (err error) {
 }
 
 func (f *File) Flush() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
 	return f.Writer.Flush()
 }
 
@@ -76,6 +87,9 @@ func (f *File) Close() error {
 		return err
 	}
 
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
 	return f.Pointer.Close()
 }
 
@@ -97,6 +111,41 @@ func GetOutputFilePrefix(path string, domain string) (absPath string, err error)
 	return
 }
 
+// GetResumeFilePrefix returns the prefix of the walk to resume, for a directory it is the latest walk of the domain in it.
+func GetResumeFilePrefix(path string, domain string) (absPath string, err error) {
+	absPath, err = getAbsolutePath(path)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(absPath)
+	if err != nil || !info.IsDir() {
+		return absPath, nil
+	}
+
+	filePaths, err := filepath.Glob(filepath.Join(absPath, domain+"-*"+SuffixCsv))
+	if err != nil {
+		return "", err
+	}
+
+	latest := ""
+	reDate := regexp.MustCompile(`^\d{4}_\d{2}_\d{2}-\d{2}_\d{2}$`)
+
+	for _, filePath := range filePaths {
+		name := strings.TrimSuffix(filepath.Base(filePath), SuffixCsv)
+
+		if reDate.MatchString(name[len(domain)+1:]) && name > latest {
+			latest = name // the date in the name sorts as text
+		}
+	}
+
+	if latest == "" {
+		return "", fmt.Errorf("Nothing to resume, no walk of %s in %s", domain, absPath)
+	}
+
+	return filepath.Join(absPath, latest), nil
+}
+
 func getAbsolutePath(path string) (absPath string, err error) {
 	absPath = filepath.Clean(path)
 	absPath, err = filepath.Abs(absPath)
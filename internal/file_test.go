@@ -0,0 +1,41 @@
+package nsec3walker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestGetResumeFilePrefix checks a directory resumes the latest walk of the domain in it
+func TestGetResumeFilePrefix(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		"cz-2025_02_24-13_59.csv",
+		"cz-2025_03_01-08_00.csv",
+		"cz-2025_03_01-08_00-salt_aabb-iter_1.csv",
+		"cz-2025_04_01-08_00.log",
+		"example.cz-2025_05_01-08_00.csv",
+	}
+
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, PermFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prefix, err := GetResumeFilePrefix(dir, "cz")
+
+	if err != nil || prefix != filepath.Join(dir, "cz-2025_03_01-08_00") {
+		t.Fatalf("resumes %s with error %v", prefix, err)
+	}
+
+	if _, err = GetResumeFilePrefix(dir, "sk"); err == nil {
+		t.Fatal("resumes a walk of a domain without one")
+	}
+
+	prefix, err = GetResumeFilePrefix(filepath.Join(dir, "cz"), "cz")
+
+	if err != nil || prefix != filepath.Join(dir, "cz") {
+		t.Fatalf("resumes %s with error %v, want the prefix as it is", prefix, err)
+	}
+}
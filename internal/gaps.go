@@ -0,0 +1,159 @@
+package nsec3walker
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	GapTargets   = 1_000 // how many of the largest gaps are targeted at once
+	GapQueueSize = 4     // precomputed domains waiting for each gap
+	GapMaxQueued = 500   // hashing pauses when this many domains are waiting, keeps the CPU for other work
+	GapRefreshMs = 1_000
+)
+
+// GapQueues holds precomputed domains for the largest gaps, handed out round-robin
+// so the queries in flight are spread across distinct gaps.
+type GapQueues struct {
+	ranges  *RangeIndex
+	gaps    []Gap
+	starts  []HashDigest // of the gaps
+	version uint64       // of the gaps in the index when they were taken
+	queues  map[HashDigest][]*Domain
+	queued  int
+	next    int
+	pending *Domain
+	pendIdx int
+}
+
+func NewGapQueues(ranges *RangeIndex) (gq *GapQueues) {
+	gq = &GapQueues{
+		ranges: ranges,
+	}
+	gq.load()
+
+	return
+}
+
+// dispatchGaps sorts hashed domains into gap queues and sends them out, largest gaps first.
+func (dg *DomainGenerator) dispatchGaps(ctx context.Context, chanIn chan *Domain, chanOut chan *Domain) {
+	ticker := time.NewTicker(time.Millisecond * GapRefreshMs)
+	defer ticker.Stop()
+
+	gq := NewGapQueues(dg.ranges)
+
+	for {
+		var chanSend, chanRecv chan *Domain
+
+		if gq.pending != nil {
+			chanSend = chanOut
+		}
+
+		if !gq.isFull() {
+			chanRecv = chanIn
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			gq.refresh()
+		case chanSend <- gq.pending:
+			gq.pop()
+		case domain := <-chanRecv:
+			gq.push(domain)
+		}
+	}
+}
+
+// refresh takes the largest gaps again, only when the index changed since they were taken
+func (gq *GapQueues) refresh() {
+	if gq.ranges.gapsVersion() != gq.version {
+		gq.load()
+	}
+}
+
+func (gq *GapQueues) load() {
+	var queued []*Domain
+
+	for _, queue := range gq.queues {
+		queued = append(queued, queue...)
+	}
+
+	gq.version = gq.ranges.gapsVersion()
+	gq.gaps = gq.ranges.Gaps(GapTargets)
+	gq.starts = make([]HashDigest, len(gq.gaps))
+	gq.queues = make(map[HashDigest][]*Domain, len(gq.gaps))
+	gq.queued = 0
+	gq.next = 0
+	gq.pending = nil
+
+	for i, gap := range gq.gaps {
+		gq.starts[i], _ = DecodeHash(gap.Start) // the gap of an empty index has no start, it stays the zero hash
+		gq.queues[gq.starts[i]] = nil
+	}
+
+	// gaps could split or close since the domains were queued, sort them again
+	for _, domain := range queued {
+		gq.push(domain)
+	}
+
+	gq.setPending()
+}
+
+func (gq *GapQueues) isFull() bool {
+	return gq.queued >= min(len(gq.gaps)*GapQueueSize, GapMaxQueued)
+}
+
+func (gq *GapQueues) push(domain *Domain) {
+	gapStart, ok := gq.ranges.gapFor(domain.digest)
+
+	if !ok {
+		return
+	}
+
+	queue, isTarget := gq.queues[gapStart]
+
+	if !isTarget || len(queue) >= GapQueueSize {
+		return
+	}
+
+	if domain.Hash == "" {
+		domain.Hash = encodeHash(domain.digest) // only domains which are sent out get encoded
+	}
+
+	gq.queues[gapStart] = append(queue, domain)
+	gq.queued++
+
+	if gq.pending == nil {
+		gq.setPending()
+	}
+}
+
+func (gq *GapQueues) pop() {
+	gapStart := gq.starts[gq.pendIdx]
+	gq.queues[gapStart] = gq.queues[gapStart][1:]
+	gq.queued--
+	gq.next = (gq.pendIdx + 1) % len(gq.gaps)
+	gq.pending = nil
+	gq.setPending()
+}
+
+// setPending picks the next domain to send, going round-robin from the largest gap
+func (gq *GapQueues) setPending() {
+	if gq.queued == 0 {
+		return
+	}
+
+	for i := range gq.gaps {
+		idx := (gq.next + i) % len(gq.gaps)
+		queue := gq.queues[gq.starts[idx]]
+
+		if len(queue) > 0 {
+			gq.pending = queue[0]
+			gq.pendIdx = idx
+
+			return
+		}
+	}
+}
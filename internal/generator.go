@@ -1,6 +1,7 @@
 package nsec3walker
 
 import (
+	"context"
 	"fmt"
 	"hash/crc32"
 	"os"
@@ -10,8 +11,10 @@ import (
 )
 
 const (
-	charset       = "abcdefghijklmnopqrstuvwxyz0123456789"
-	cntChanDomain = 2_000
+	charset            = "abcdefghijklmnopqrstuvwxyz0123456789"
+	cntChanDomain      = 2_000
+	StrategyGaps       = "gaps"
+	StrategySequential = "sequential"
 )
 
 type DomainGenerator struct {
@@ -19,6 +22,7 @@ type DomainGenerator struct {
 	ranges      *RangeIndex
 	out         *Output
 	nsec3Params Nsec3Params
+	strategy    string
 	counter     []int8
 	chars       []rune
 	len         int8
@@ -26,7 +30,8 @@ type DomainGenerator struct {
 
 type Domain struct {
 	Domain string
-	Hash   string
+	Hash   string     // encoded only for domains being sent out
+	digest HashDigest // of the Hash, for lookups in the ranges
 }
 
 func NewDomainGenerator(
@@ -35,6 +40,7 @@ func NewDomainGenerator(
 	nsec3Iter uint16,
 	ranges *RangeIndex,
 	output *Output,
+	strategy string,
 ) (dg *DomainGenerator, err error) {
 	n3p, err := NewNsec3Params(nsec3Domain, nsec3Salt, int(nsec3Iter))
 	if err != nil {
@@ -46,6 +52,7 @@ func NewDomainGenerator(
 		ranges:      ranges,
 		out:         output,
 		nsec3Params: n3p,
+		strategy:    strategy,
 		counter:     []int8{0, 0, 0, 0}, // "aaaa"
 		chars:       []rune(charset),
 		len:         int8(len(charset)),
@@ -54,38 +61,75 @@ func NewDomainGenerator(
 	return
 }
 
-func (dg *DomainGenerator) Run(chanOut chan *Domain) {
-	go dg.generateDomains()
+// Run starts generating domains not covered by known ranges. With StrategyGaps, the domains are
+// sorted by the gap they fall into and handed out so the largest gaps are targeted first.
+func (dg *DomainGenerator) Run(ctx context.Context, chanOut chan *Domain) {
+	chanHashed := chanOut
+
+	if dg.strategy == StrategyGaps {
+		chanHashed = make(chan *Domain, cntChanDomain)
+		go dg.dispatchGaps(ctx, chanHashed, chanOut)
+	}
+
+	go dg.generateDomains(ctx)
 
 	for i := 0; i < runtime.NumCPU(); i++ {
-		go dg.hashWorker(chanOut)
+		go dg.hashWorker(ctx, chanHashed)
 	}
 }
 
-func (dg *DomainGenerator) hashWorker(chanOut chan *Domain) {
-	var err error
+func (dg *DomainGenerator) hashWorker(ctx context.Context, chanOut chan *Domain) {
+	var domain *Domain
+
+	hasher, err := dg.nsec3Params.NewHasher()
+
+	if err != nil {
+		dg.out.Log("Can't calculate NSEC3 hashes: " + err.Error())
+
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case domain = <-dg.chanDomain:
+		}
 
-	for domain := range dg.chanDomain {
-		domain.Hash, err = dg.nsec3Params.CalculateHashForPrefix(domain.Domain)
+		domain.digest, err = hasher.Digest(domain.Domain)
 		if err != nil {
 			dg.out.Log("Error calculating NSEC3 hash for domain " + domain.Domain + ": " + err.Error())
 
 			continue
 		}
 
-		inRange, _ := dg.ranges.isHashInRange(domain.Hash)
+		// gap dispatcher is looking up the gap anyway, that tells if the hash is covered, and encodes the hash
+		if dg.strategy != StrategyGaps {
+			if _, inRange := dg.ranges.rangeOf(domain.digest); inRange {
+				continue
+			}
 
-		if !inRange {
-			chanOut <- &Domain{Domain: domain.Domain, Hash: domain.Hash}
+			domain.Hash = hasher.Encode(domain.digest)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case chanOut <- domain:
 		}
 	}
 }
 
-func (dg *DomainGenerator) generateDomains() {
+func (dg *DomainGenerator) generateDomains(ctx context.Context) {
 	suffix := dg.getRandomPrefix() + "." + dg.nsec3Params.domain
 
 	for {
-		dg.chanDomain <- &Domain{Domain: dg.toString() + suffix}
+		select {
+		case <-ctx.Done():
+			return
+		case dg.chanDomain <- &Domain{Domain: dg.toString() + suffix}:
+		}
+
 		dg.next()
 	}
 }
@@ -0,0 +1,176 @@
+package nsec3walker
+
+import (
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"strings"
+)
+
+const HashSize = sha1.Size
+
+// hashEncoding is base32hex in lowercase without padding, as NSEC3 owner names are written
+var hashEncoding = base32.NewEncoding("0123456789abcdefghijklmnopqrstuv").WithPadding(base32.NoPadding)
+
+type HashDigest [HashSize]byte
+
+// Nsec3Hasher calculates NSEC3 hashes of names in one zone. Wire format of the zone is prepared once
+// and buffers are reused between names, so it is not safe for concurrent use, use one per goroutine.
+type Nsec3Hasher struct {
+	n3p     Nsec3Params
+	suffix  []byte // wire format of the zone, including the root label
+	wire    []byte // name in wire format followed by the salt
+	rounds  []byte // digest of the previous round followed by the salt
+	encoded [32]byte
+}
+
+func (n3p Nsec3Params) NewHasher() (hasher *Nsec3Hasher, err error) {
+	hasher = &Nsec3Hasher{
+		n3p:    n3p,
+		wire:   make([]byte, 0, 256+len(n3p.saltBytes)),
+		rounds: make([]byte, HashSize+len(n3p.saltBytes)),
+	}
+
+	copy(hasher.rounds[HashSize:], n3p.saltBytes)
+
+	if n3p.domain != "" {
+		hasher.suffix, err = appendWire(nil, n3p.domain)
+	}
+
+	hasher.suffix = append(hasher.suffix, 0)
+
+	return
+}
+
+// DigestPrefix returns the raw hash of the name prefix.zone, an empty prefix is the zone apex.
+func (h *Nsec3Hasher) DigestPrefix(prefix string) (digest HashDigest, err error) {
+	h.wire, err = appendWire(h.wire[:0], prefix)
+
+	if err != nil {
+		return
+	}
+
+	h.wire = append(h.wire, h.suffix...)
+
+	return h.digestWire(), nil
+}
+
+// Digest returns the raw hash of a full domain name, which has to be within the zone.
+func (h *Nsec3Hasher) Digest(name string) (digest HashDigest, err error) {
+	name = strings.TrimSuffix(name, ".")
+	zone := h.n3p.domain
+
+	if strings.EqualFold(name, zone) {
+		return h.DigestPrefix("")
+	}
+
+	if zone != "" && len(name) > len(zone) && name[len(name)-len(zone)-1] == '.' && strings.EqualFold(name[len(name)-len(zone):], zone) {
+		return h.DigestPrefix(name[:len(name)-len(zone)-1])
+	}
+
+	if name == "" {
+		return digest, fmt.Errorf("empty domain name")
+	}
+
+	h.wire, err = appendWire(h.wire[:0], name)
+
+	if err != nil {
+		return
+	}
+
+	h.wire = append(h.wire, 0)
+
+	return h.digestWire(), nil
+}
+
+// HashPrefix returns the hash of the name prefix.zone, as used in NSEC3 owner names.
+func (h *Nsec3Hasher) HashPrefix(prefix string) (hash string, err error) {
+	digest, err := h.DigestPrefix(prefix)
+
+	if err == nil {
+		hash = h.Encode(digest)
+	}
+
+	return
+}
+
+// Hash returns the hash of a full domain name, as used in NSEC3 owner names.
+func (h *Nsec3Hasher) Hash(name string) (hash string, err error) {
+	digest, err := h.Digest(name)
+
+	if err == nil {
+		hash = h.Encode(digest)
+	}
+
+	return
+}
+
+func (h *Nsec3Hasher) Encode(digest HashDigest) string {
+	hashEncoding.Encode(h.encoded[:], digest[:])
+
+	return string(h.encoded[:])
+}
+
+// digestWire hashes the name in h.wire, RFC 5155 section 5
+func (h *Nsec3Hasher) digestWire() (digest HashDigest) {
+	h.wire = append(h.wire, h.n3p.saltBytes...)
+	digest = sha1.Sum(h.wire)
+
+	for i := uint16(0); i < h.n3p.iterations; i++ {
+		copy(h.rounds, digest[:])
+		digest = sha1.Sum(h.rounds)
+	}
+
+	return
+}
+
+// DecodeHash returns the raw hash of a NSEC3 owner name hash.
+func DecodeHash(hash string) (digest HashDigest, err error) {
+	var decoded [HashSize + 1]byte // the decoder may need a spare byte
+	n, err := hashEncoding.Decode(decoded[:], []byte(strings.ToLower(hash)))
+
+	if err == nil && n != HashSize {
+		err = fmt.Errorf("hash %s has %d bytes instead of %d", hash, n, HashSize)
+	}
+
+	copy(digest[:], decoded[:HashSize])
+
+	return
+}
+
+func isHash(hash string) bool {
+	_, err := DecodeHash(hash)
+
+	return err == nil && len(hash) == hashEncoding.EncodedLen(HashSize)
+}
+
+// appendWire appends labels of the name in the canonical wire format (RFC 4034 section 6.2), without the root label.
+func appendWire(wire []byte, name string) ([]byte, error) {
+	if name == "" {
+		return wire, nil
+	}
+
+	for label := range strings.SplitSeq(name, ".") {
+		if len(label) > 63 {
+			return wire, fmt.Errorf("label too long: %s", label)
+		}
+
+		if len(label) == 0 {
+			return wire, fmt.Errorf("empty label in domain name")
+		}
+
+		wire = append(wire, byte(len(label)))
+
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+
+			wire = append(wire, c)
+		}
+	}
+
+	return wire, nil
+}
@@ -0,0 +1,140 @@
+package nsec3walker
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func testHasher(t testing.TB, domain string, salt string, iterations int) (n3p Nsec3Params, hasher *Nsec3Hasher) {
+	t.Helper()
+
+	n3p, err := NewNsec3Params(domain, salt, iterations)
+
+	if err == nil {
+		hasher, err = n3p.NewHasher()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+// TestHasherMatchesHashName compares hashes of prefixes and full names with the miekg/dns implementation
+func TestHasherMatchesHashName(t *testing.T) {
+	label63 := strings.Repeat("a", 63)
+	prefixes := []string{"", "www", "WWW", "a.b.c", "xn--hkyrky-g2a", "_dmarc", label63}
+
+	for _, zone := range []string{"cz", "example.com", "Example.COM"} {
+		for _, salt := range []string{"", "aabbccdd", "0123456789abcdef"} {
+			for _, iterations := range []int{0, 1, 10, 100} {
+				n3p, hasher := testHasher(t, zone, salt, iterations)
+
+				for _, prefix := range prefixes {
+					name := n3p.GetFullDomain(prefix)
+					want := strings.ToLower(dns.HashName(dns.Fqdn(name), dns.SHA1, uint16(iterations), salt))
+
+					hash, err := hasher.HashPrefix(prefix)
+
+					if err != nil || hash != want {
+						t.Fatalf("HashPrefix(%q) of [%s] salt [%s] %d: %s %v, want %s", prefix, zone, salt, iterations, hash, err, want)
+					}
+
+					for _, full := range []string{name, name + ".", strings.ToUpper(name)} {
+						hash, err = hasher.Hash(full)
+
+						if err != nil || hash != want {
+							t.Fatalf("Hash(%q) salt [%s] %d: %s %v, want %s", full, salt, iterations, hash, err, want)
+						}
+					}
+
+					hash, err = n3p.CalculateHashForPrefix(prefix)
+
+					if err != nil || hash != want {
+						t.Fatalf("CalculateHashForPrefix(%q): %s %v, want %s", prefix, hash, err, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestHasherOutsideZone hashes names which are not below the zone as they are
+func TestHasherOutsideZone(t *testing.T) {
+	_, hasher := testHasher(t, "example.com", "aabb", 5)
+
+	for _, name := range []string{"com", "notexample.com", "example.com.cz"} {
+		want := strings.ToLower(dns.HashName(dns.Fqdn(name), dns.SHA1, 5, "aabb"))
+
+		if hash, err := hasher.Hash(name); err != nil || hash != want {
+			t.Fatalf("Hash(%q): %s %v, want %s", name, hash, err, want)
+		}
+	}
+}
+
+func TestHasherInvalidNames(t *testing.T) {
+	_, hasher := testHasher(t, "example.com", "", 0)
+
+	for _, prefix := range []string{"a..b", ".www", strings.Repeat("a", 64)} {
+		if _, err := hasher.HashPrefix(prefix); err == nil {
+			t.Fatalf("HashPrefix(%q) got no error", prefix)
+		}
+	}
+
+	if _, err := hasher.Hash(""); err == nil {
+		t.Fatal("empty name got no error")
+	}
+}
+
+func TestDecodeHash(t *testing.T) {
+	_, hasher := testHasher(t, "example.com", "aabb", 1)
+	digest, err := hasher.DigestPrefix("www")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := hasher.Encode(digest)
+
+	for _, encoded := range []string{hash, strings.ToUpper(hash)} {
+		if decoded, errDecode := DecodeHash(encoded); errDecode != nil || decoded != digest {
+			t.Fatalf("DecodeHash(%s): %x %v, want %x", encoded, decoded, errDecode, digest)
+		}
+	}
+
+	if !isHash(hash) || isHash(hash[:20]) || isHash(hash+"0") {
+		t.Fatal("isHash accepts a hash of a wrong length")
+	}
+}
+
+var benchmarkIterations = []int{0, 10, 100, 2500}
+
+func BenchmarkHasherDigestPrefix(b *testing.B) {
+	for _, iterations := range benchmarkIterations {
+		b.Run(fmt.Sprintf("iter_%d", iterations), func(b *testing.B) {
+			_, hasher := testHasher(b, "example.com", "aabbccdd", iterations)
+			b.ReportAllocs()
+
+			for n := 0; n < b.N; n++ {
+				_, _ = hasher.DigestPrefix("www")
+			}
+		})
+	}
+}
+
+// BenchmarkHashName is the miekg/dns implementation, for comparison
+func BenchmarkHashName(b *testing.B) {
+	for _, iterations := range benchmarkIterations {
+		b.Run(fmt.Sprintf("iter_%d", iterations), func(b *testing.B) {
+			b.ReportAllocs()
+
+			for n := 0; n < b.N; n++ {
+				dns.HashName("www.example.com.", dns.SHA1, uint16(iterations), "aabbccdd")
+			}
+		})
+	}
+}
@@ -0,0 +1,438 @@
+package nsec3walker
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"iter"
+	"slices"
+)
+
+const hashTreeOrder = 128 // max ranges in a leaf and children of an inner node
+
+// HashTree is a B+ tree of ranges keyed by raw hashes. Ranges are stored by value in the leaves, about 60 bytes
+// per hash with half full leaves, and the leaves are linked for walking in order. Nodes emptied by removals
+// are dropped, but not merged, as removals are rare. It is not synchronized, RangeIndex holds the lock.
+type HashTree struct {
+	root  *hashTreeNode
+	first *hashTreeNode // leftmost leaf
+	last  *hashTreeNode // rightmost leaf
+	size  int
+}
+
+// hashRange starts at a known hash, the end is unknown while hasEnd is false
+type hashRange struct {
+	start  HashDigest
+	end    HashDigest
+	hasEnd bool
+}
+
+// hashNeighbours are the ranges a change at a hash affects, before and after it wrap around the hash space
+type hashNeighbours struct {
+	before  hashRange
+	at      hashRange
+	after   hashRange
+	hasAt   bool
+	isEmpty bool // there are no ranges at all
+}
+
+type hashTreeNode struct {
+	ranges   []hashRange  // leaf only, sorted by start
+	keys     []HashDigest // inner only, keys[i] is lower bound of children[i+1]
+	children []*hashTreeNode
+	prev     *hashTreeNode // leaves only
+	next     *hashTreeNode
+}
+
+// hashTreePos points to a range in a leaf, zero value is no range
+type hashTreePos struct {
+	leaf *hashTreeNode
+	i    int
+}
+
+func NewHashTree() (hashTree *HashTree) {
+	leaf := newHashTreeLeaf()
+	hashTree = &HashTree{root: leaf, first: leaf, last: leaf}
+
+	return
+}
+
+func newHashTreeLeaf() *hashTreeNode {
+	return &hashTreeNode{ranges: make([]hashRange, 0, hashTreeOrder+1)}
+}
+
+// compareHash orders hashes as their encoded form, the first 8 bytes decide almost always
+func compareHash(a HashDigest, b HashDigest) int {
+	if c := cmp.Compare(binary.BigEndian.Uint64(a[:8]), binary.BigEndian.Uint64(b[:8])); c != 0 {
+		return c
+	}
+
+	return bytes.Compare(a[8:], b[8:])
+}
+
+func (n *hashTreeNode) isLeaf() bool {
+	return n.children == nil
+}
+
+func (n *hashTreeNode) child(hash HashDigest) int {
+	return searchKeys(n.keys, hash)
+}
+
+func (n *hashTreeNode) search(hash HashDigest) (int, bool) {
+	return searchRanges(n.ranges, hash)
+}
+
+// searchKeys returns index of the child which could hold the hash, by the lower bounds of the children
+func searchKeys(keys []HashDigest, hash HashDigest) int {
+	i, found := slices.BinarySearchFunc(keys, hash, compareHash)
+
+	if found {
+		i++
+	}
+
+	return i
+}
+
+func searchRanges(ranges []hashRange, hash HashDigest) (int, bool) {
+	return slices.BinarySearchFunc(ranges, hash, func(r hashRange, hash HashDigest) int {
+		return compareHash(r.start, hash)
+	})
+}
+
+func (ht *HashTree) leafFor(hash HashDigest) (node *hashTreeNode) {
+	for node = ht.root; !node.isLeaf(); {
+		node = node.children[node.child(hash)]
+	}
+
+	return
+}
+
+func (ht *HashTree) Len() int {
+	return ht.size
+}
+
+func (ht *HashTree) get(hash HashDigest) (r hashRange, ok bool) {
+	leaf := ht.leafFor(hash)
+
+	if i, found := leaf.search(hash); found {
+		return leaf.ranges[i], true
+	}
+
+	return
+}
+
+// floor returns the range with the largest start equal to or smaller than the hash
+func (ht *HashTree) floor(hash HashDigest) (hashRange, bool) {
+	leaf := ht.leafFor(hash)
+	i, found := leaf.search(hash)
+
+	if found {
+		return hashTreePos{leaf, i}.value()
+	}
+
+	return hashTreePos{leaf, i}.prev().value()
+}
+
+// lower returns the range with the largest start smaller than the hash
+func (ht *HashTree) lower(hash HashDigest) (hashRange, bool) {
+	leaf := ht.leafFor(hash)
+	i, _ := leaf.search(hash)
+
+	return hashTreePos{leaf, i}.prev().value()
+}
+
+// ceiling returns the range with the smallest start equal to or larger than the hash
+func (ht *HashTree) ceiling(hash HashDigest) hashTreePos {
+	leaf := ht.leafFor(hash)
+	i, _ := leaf.search(hash)
+
+	return hashTreePos{leaf, i - 1}.next()
+}
+
+// higher returns the range with the smallest start larger than the hash
+func (ht *HashTree) higher(hash HashDigest) hashTreePos {
+	leaf := ht.leafFor(hash)
+	i, found := leaf.search(hash)
+
+	if !found {
+		i--
+	}
+
+	return hashTreePos{leaf, i}.next()
+}
+
+func (ht *HashTree) minPos() hashTreePos {
+	return hashTreePos{ht.first, -1}.next()
+}
+
+func (ht *HashTree) maxPos() hashTreePos {
+	return hashTreePos{ht.last, len(ht.last.ranges)}.prev()
+}
+
+func (ht *HashTree) max() (hashRange, bool) {
+	return ht.maxPos().value()
+}
+
+// around returns the range at the hash, if it exists, and the ranges before and after it,
+// wrapping around the hash space. It is what a change at the hash affects, found in one descent.
+func (ht *HashTree) around(hash HashDigest) (nb hashNeighbours) {
+	leaf := ht.leafFor(hash)
+	i, found := leaf.search(hash)
+	before := hashTreePos{leaf, i}.prev()
+	after := hashTreePos{leaf, i - 1}.next()
+
+	if found {
+		nb.at, nb.hasAt = leaf.ranges[i], true
+		after = hashTreePos{leaf, i}.next()
+	}
+
+	if !before.valid() {
+		before = ht.maxPos()
+	}
+
+	if !after.valid() {
+		after = ht.minPos()
+	}
+
+	var ok bool
+
+	nb.before, ok = before.value()
+	nb.after, _ = after.value()
+	nb.isEmpty = !ok
+
+	return
+}
+
+// higherCyclic is higher wrapping around the end of the hash space
+func (ht *HashTree) higherCyclic(hash HashDigest) (hashRange, bool) {
+	pos := ht.higher(hash)
+
+	if !pos.valid() {
+		pos = ht.minPos()
+	}
+
+	return pos.value()
+}
+
+// ascend yields ranges in order, starting with the first one equal to or larger than the hash
+func (ht *HashTree) ascend(from HashDigest) iter.Seq[hashRange] {
+	return func(yield func(hashRange) bool) {
+		for pos := ht.ceiling(from); pos.valid(); pos = pos.next() {
+			if !yield(pos.get()) {
+				return
+			}
+		}
+	}
+}
+
+func (ht *HashTree) all() iter.Seq[hashRange] {
+	return ht.ascend(HashDigest{})
+}
+
+// Err is always nil, the tree lives in memory only
+func (ht *HashTree) Err() error {
+	return nil
+}
+
+func (ht *HashTree) Close() error {
+	return nil
+}
+
+// put adds the range or replaces the one with the same start
+func (ht *HashTree) put(r hashRange) (isNew bool) {
+	isNew, split, splitKey := ht.insert(ht.root, r)
+
+	if split != nil {
+		ht.root = &hashTreeNode{
+			keys:     append(make([]HashDigest, 0, hashTreeOrder), splitKey),
+			children: append(make([]*hashTreeNode, 0, hashTreeOrder+1), ht.root, split),
+		}
+	}
+
+	if isNew {
+		ht.size++
+	}
+
+	return
+}
+
+// insert returns the new right sibling and its lower bound when the node had to be split
+func (ht *HashTree) insert(node *hashTreeNode, r hashRange) (isNew bool, split *hashTreeNode, splitKey HashDigest) {
+	if node.isLeaf() {
+		i, found := node.search(r.start)
+
+		if found {
+			node.ranges[i] = r
+
+			return
+		}
+
+		node.ranges = slices.Insert(node.ranges, i, r)
+
+		if len(node.ranges) > hashTreeOrder {
+			split = ht.splitLeaf(node)
+			splitKey = split.ranges[0].start
+		}
+
+		return true, split, splitKey
+	}
+
+	i := node.child(r.start)
+	isNew, childSplit, childKey := ht.insert(node.children[i], r)
+
+	if childSplit == nil {
+		return
+	}
+
+	node.keys = slices.Insert(node.keys, i, childKey)
+	node.children = slices.Insert(node.children, i+1, childSplit)
+
+	if len(node.children) > hashTreeOrder {
+		split, splitKey = splitInner(node)
+	}
+
+	return
+}
+
+func (ht *HashTree) splitLeaf(leaf *hashTreeNode) (right *hashTreeNode) {
+	half := len(leaf.ranges) / 2
+	right = newHashTreeLeaf()
+	right.ranges = append(right.ranges, leaf.ranges[half:]...)
+	leaf.ranges = leaf.ranges[:half]
+
+	right.prev, right.next = leaf, leaf.next
+
+	if leaf.next == nil {
+		ht.last = right
+	} else {
+		leaf.next.prev = right
+	}
+
+	leaf.next = right
+
+	return
+}
+
+func splitInner(node *hashTreeNode) (right *hashTreeNode, splitKey HashDigest) {
+	half := len(node.children) / 2
+	splitKey = node.keys[half-1]
+	right = &hashTreeNode{
+		keys:     append(make([]HashDigest, 0, hashTreeOrder), node.keys[half:]...),
+		children: append(make([]*hashTreeNode, 0, hashTreeOrder+1), node.children[half:]...),
+	}
+
+	clear(node.children[half:]) // drop references for the garbage collector
+	node.keys = node.keys[:half-1]
+	node.children = node.children[:half]
+
+	return
+}
+
+func (ht *HashTree) remove(hash HashDigest) (removed bool) {
+	removed, _ = ht.delete(ht.root, hash)
+
+	for !ht.root.isLeaf() && len(ht.root.children) == 1 {
+		ht.root = ht.root.children[0]
+	}
+
+	if !ht.root.isLeaf() && len(ht.root.children) == 0 {
+		ht.root = newHashTreeLeaf()
+		ht.first, ht.last = ht.root, ht.root
+	}
+
+	if removed {
+		ht.size--
+	}
+
+	return
+}
+
+// delete removes the hash from the subtree, isEmpty tells the parent to drop the node
+func (ht *HashTree) delete(node *hashTreeNode, hash HashDigest) (removed bool, isEmpty bool) {
+	if node.isLeaf() {
+		i, found := node.search(hash)
+
+		if !found {
+			return
+		}
+
+		node.ranges = slices.Delete(node.ranges, i, i+1)
+		isEmpty = len(node.ranges) == 0 && node != ht.root
+
+		if isEmpty {
+			ht.unlink(node)
+		}
+
+		return true, isEmpty
+	}
+
+	i := node.child(hash)
+	removed, isChildEmpty := ht.delete(node.children[i], hash)
+
+	if isChildEmpty {
+		node.children = slices.Delete(node.children, i, i+1)
+
+		if i > 0 {
+			node.keys = slices.Delete(node.keys, i-1, i)
+		} else if len(node.keys) > 0 {
+			node.keys = slices.Delete(node.keys, 0, 1)
+		}
+	}
+
+	return removed, len(node.children) == 0 && node != ht.root
+}
+
+func (ht *HashTree) unlink(leaf *hashTreeNode) {
+	if leaf.prev == nil {
+		ht.first = leaf.next
+	} else {
+		leaf.prev.next = leaf.next
+	}
+
+	if leaf.next == nil {
+		ht.last = leaf.prev
+	} else {
+		leaf.next.prev = leaf.prev
+	}
+}
+
+func (pos hashTreePos) valid() bool {
+	return pos.leaf != nil && pos.i >= 0 && pos.i < len(pos.leaf.ranges)
+}
+
+func (pos hashTreePos) get() hashRange {
+	return pos.leaf.ranges[pos.i]
+}
+
+// value returns the range, ok is false if the position is not valid
+func (pos hashTreePos) value() (r hashRange, ok bool) {
+	if pos.valid() {
+		r, ok = pos.get(), true
+	}
+
+	return
+}
+
+func (pos hashTreePos) next() hashTreePos {
+	for leaf, i := pos.leaf, pos.i+1; leaf != nil; leaf, i = leaf.next, 0 {
+		if i < len(leaf.ranges) {
+			return hashTreePos{leaf, i}
+		}
+	}
+
+	return hashTreePos{}
+}
+
+func (pos hashTreePos) prev() hashTreePos {
+	for leaf, i := pos.leaf, pos.i-1; leaf != nil; leaf = leaf.prev {
+		if i >= 0 {
+			return hashTreePos{leaf, i}
+		}
+
+		if leaf.prev != nil {
+			i = len(leaf.prev.ranges) - 1
+		}
+	}
+
+	return hashTreePos{}
+}
@@ -0,0 +1,224 @@
+package nsec3walker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	HealthBenchSec         = 30
+	HealthBenchMaxSec      = 600
+	HealthEwmaWeight       = 0.2
+	HealthMaxConflictRatio = 0.1
+	HealthMaxErrorsInRow   = 5
+	HealthMinQueries       = 20
+	HealthMinSuccessRate   = 0.2
+	HealthProbeWaitMs      = 500
+)
+
+// HealthTracker keeps health of every NS server address, unhealthy ones are benched for a while.
+type HealthTracker struct {
+	out     *Output
+	mutex   sync.Mutex
+	servers map[string]*NsHealth
+	order   []string
+}
+
+// NsHealth is shared by all workers of a NS server. Window counters are reset with every bench,
+// totals are kept for the final scorecard.
+type NsHealth struct {
+	name         string
+	out          *Output
+	mutex        sync.Mutex
+	queries      int
+	successes    int
+	errors       int
+	conflicts    int
+	cntBenched   int
+	errorsInRow  int
+	winQueries   int
+	winSuccesses int
+	winConflicts int
+	latencyEwma  time.Duration
+	benchedUntil time.Time
+	probing      bool
+}
+
+func NewHealthTracker(out *Output) (ht *HealthTracker) {
+	ht = &HealthTracker{
+		out:     out,
+		servers: make(map[string]*NsHealth),
+	}
+
+	return
+}
+
+func (ht *HealthTracker) get(name string) (health *NsHealth) {
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+
+	health, ok := ht.servers[name]
+
+	if !ok {
+		health = &NsHealth{name: name, out: ht.out}
+		ht.servers[name] = health
+		ht.order = append(ht.order, name)
+	}
+
+	return
+}
+
+// conflict records a range from the server which doesn't match the already known one.
+func (ht *HealthTracker) conflict(name string) {
+	ht.get(name).conflict()
+}
+
+func (ht *HealthTracker) logScorecard() {
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+
+	for _, name := range ht.order {
+		ht.out.Log(ht.servers[name].scorecard())
+	}
+}
+
+// waitAvailable blocks while the server is benched, returns false if the context was cancelled.
+// Once the bench is over, a single worker gets through to probe the server.
+func (h *NsHealth) waitAvailable(ctx context.Context) bool {
+	for {
+		wait := h.benchWait()
+
+		if wait == 0 {
+			return true
+		}
+
+		if !sleepCtx(ctx, wait) {
+			return false
+		}
+	}
+}
+
+func (h *NsHealth) benchWait() time.Duration {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.benchedUntil.IsZero() {
+		return 0
+	}
+
+	if wait := time.Until(h.benchedUntil); wait > 0 {
+		return wait
+	}
+
+	if !h.probing {
+		h.probing = true
+
+		return 0
+	}
+
+	return time.Millisecond * HealthProbeWaitMs
+}
+
+// cancelProbe releases the probe slot of a worker which got through waitAvailable but didn't send a query.
+func (h *NsHealth) cancelProbe() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.probing = false
+}
+
+func (h *NsHealth) record(err error, latency time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.queries++
+	h.winQueries++
+
+	if err == nil {
+		h.successes++
+		h.winSuccesses++
+		h.errorsInRow = 0
+		h.updateLatency(latency)
+
+		if h.probing {
+			h.probing = false
+			h.benchedUntil = time.Time{}
+			h.out.Logf("NS [%s] is answering again, back from the bench", h.name)
+		}
+
+		return
+	}
+
+	h.errors++
+	h.errorsInRow++
+
+	if h.probing || h.benchedUntil.IsZero() && h.isUnhealthy() {
+		h.probing = false
+		h.bench()
+	}
+}
+
+func (h *NsHealth) conflict() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.conflicts++
+	h.winConflicts++
+
+	if h.benchedUntil.IsZero() && h.isUnhealthy() {
+		h.bench()
+	}
+}
+
+func (h *NsHealth) isUnhealthy() bool {
+	if h.errorsInRow >= HealthMaxErrorsInRow {
+		return true
+	}
+
+	if h.winQueries >= HealthMinQueries && float64(h.winSuccesses)/float64(h.winQueries) < HealthMinSuccessRate {
+		return true
+	}
+
+	return h.winSuccesses >= HealthMinQueries && float64(h.winConflicts)/float64(h.winSuccesses) > HealthMaxConflictRatio
+}
+
+// bench doubles the time with every bench of the same server
+func (h *NsHealth) bench() {
+	h.cntBenched++
+	duration := time.Second * HealthBenchSec << min(h.cntBenched-1, 10)
+	duration = min(duration, time.Second*HealthBenchMaxSec)
+	h.benchedUntil = time.Now().Add(duration)
+	h.errorsInRow = 0
+	h.winQueries = 0
+	h.winSuccesses = 0
+	h.winConflicts = 0
+
+	h.out.Logf("NS [%s] is unhealthy, benched for %v", h.name, duration)
+}
+
+func (h *NsHealth) updateLatency(latency time.Duration) {
+	if h.latencyEwma == 0 {
+		h.latencyEwma = latency
+
+		return
+	}
+
+	h.latencyEwma = time.Duration(HealthEwmaWeight*float64(latency) + (1-HealthEwmaWeight)*float64(h.latencyEwma))
+}
+
+func (h *NsHealth) scorecard() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	successRate := 0
+
+	if h.queries > 0 {
+		successRate = h.successes * 100 / h.queries
+	}
+
+	msg := "Scorecard [%s]: Queries %d | Success %d%% | Latency %v | Errors %d | Conflicts %d | Benched %d times"
+
+	return fmt.Sprintf(msg, h.name, h.queries, successRate, h.latencyEwma.Round(time.Microsecond), h.errors, h.conflicts, h.cntBenched)
+}
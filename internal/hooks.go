@@ -0,0 +1,112 @@
+package nsec3walker
+
+import "time"
+
+// Hooks let programs embedding the walker follow its progress, nil hooks are skipped.
+// They are called synchronously from the walk, so they should return quickly.
+type Hooks struct {
+	OnRange    func(RangeEvent)
+	OnHash     func(HashEvent)
+	OnName     func(NameEvent) // names of a zone signed with NSEC, instead of ranges and hashes
+	OnProgress func(ProgressEvent)
+	OnError    func(error) // errors the walk continues after, the fatal one is returned from the walk
+}
+
+// RangeEvent is a new range of the NSEC3 chain.
+type RangeEvent struct {
+	Domain     string
+	Start      string
+	End        string
+	Salt       string
+	Iterations int
+	Types      []string
+	NameServer string
+	Validation string
+}
+
+// NameEvent is a cleartext name from NSEC walk.
+type NameEvent struct {
+	Domain string
+	Name   string
+	Types  []string
+}
+
+// HashEvent is a new NSEC3 hash, with the params needed for cracking it.
+type HashEvent struct {
+	Domain     string
+	Hash       string
+	Salt       string
+	Iterations int
+}
+
+// ProgressEvent is sent with every counters interval.
+type ProgressEvent struct {
+	Domain               string
+	Queries              int64
+	Hashes               int64
+	QueriesWithoutResult int64
+	Elapsed              time.Duration
+}
+
+func NewRangeEvent(record Nsec3Record, nsec Nsec3Params) RangeEvent {
+	return RangeEvent{
+		Domain:     nsec.domain,
+		Start:      record.Start,
+		End:        record.End,
+		Salt:       nsec.saltString,
+		Iterations: int(nsec.iterations),
+		Types:      typesToStrings(record.Types),
+		NameServer: record.Ns,
+		Validation: record.Validation,
+	}
+}
+
+func NewHashEvent(hash string, nsec Nsec3Params) HashEvent {
+	return HashEvent{
+		Domain:     nsec.domain,
+		Hash:       hash,
+		Salt:       nsec.saltString,
+		Iterations: int(nsec.iterations),
+	}
+}
+
+func (nw *NSec3Walker) emitRange(record Nsec3Record, nsec Nsec3Params) {
+	if nw.config.Hooks.OnRange != nil {
+		nw.config.Hooks.OnRange(NewRangeEvent(record, nsec))
+	}
+}
+
+func (nw *NSec3Walker) emitName(name string, types []uint16) {
+	if nw.config.Hooks.OnName != nil {
+		nw.config.Hooks.OnName(NameEvent{Domain: nw.nsec.domain, Name: name, Types: typesToStrings(types)})
+	}
+}
+
+func (nw *NSec3Walker) emitHash(hash string, nsec Nsec3Params) {
+	if nw.config.Hooks.OnHash != nil {
+		nw.config.Hooks.OnHash(NewHashEvent(hash, nsec))
+	}
+}
+
+// emitProgress goes to output sinks as well as to the hook
+func (nw *NSec3Walker) emitProgress() {
+	progress := ProgressEvent{
+		Domain:               nw.nsec.domain,
+		Queries:              nw.stats.queries.Load(),
+		Hashes:               nw.stats.hashes.Load(),
+		QueriesWithoutResult: nw.stats.queriesWithoutResult.Load(),
+		Elapsed:              time.Since(nw.stats.started),
+	}
+
+	nw.out.Progress(progress)
+
+	if nw.config.Hooks.OnProgress != nil {
+		nw.config.Hooks.OnProgress(progress)
+	}
+}
+
+func (nw *NSec3Walker) emitError(err error) {
+	if nw.config.Hooks.OnError != nil {
+		nw.config.Hooks.OnError(err)
+	}
+}
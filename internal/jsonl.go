@@ -0,0 +1,201 @@
+package nsec3walker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+const (
+	BuffSizeJsonl = 64
+	JsonlStdout   = "-"
+)
+
+// JsonlSink writes one JSON object per line, for every range, cleartext name, zone change, log and progress.
+type JsonlSink struct {
+	file *File
+}
+
+type jsonlRange struct {
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Zone       string    `json:"zone"`
+	Hash       string    `json:"hash"`
+	HashNext   string    `json:"hash_next"`
+	Salt       string    `json:"salt"`
+	Iterations int       `json:"iterations"`
+	Types      []string  `json:"types"`
+	Ns         string    `json:"ns"`
+	Validation string    `json:"validation,omitempty"`
+}
+
+type jsonlName struct {
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	Zone  string    `json:"zone"`
+	Name  string    `json:"name"`
+	Types []string  `json:"types"`
+}
+
+type jsonlChange struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Start  string    `json:"start"`
+	OldEnd string    `json:"old_end"`
+	NewEnd string    `json:"new_end"`
+	Ns     string    `json:"ns"`
+}
+
+type jsonlDiff struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Zone     string    `json:"zone"`
+	Change   string    `json:"change"`
+	Kind     string    `json:"kind"`
+	Hash     string    `json:"hash,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	OldTypes []string  `json:"old_types,omitempty"`
+	NewTypes []string  `json:"new_types,omitempty"`
+}
+
+type jsonlLog struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+type jsonlProgress struct {
+	Type                 string    `json:"type"`
+	Time                 time.Time `json:"time"`
+	Zone                 string    `json:"zone"`
+	Queries              int64     `json:"queries"`
+	Hashes               int64     `json:"hashes"`
+	QueriesWithoutResult int64     `json:"queries_without_result"`
+	ElapsedSec           int64     `json:"elapsed_sec"`
+}
+
+// NewJsonlSink appends into the file, or writes to stdout for JsonlStdout.
+func NewJsonlSink(filePath string) (sink *JsonlSink, err error) {
+	sink = &JsonlSink{}
+
+	if filePath == JsonlStdout {
+		sink.file = &File{
+			Name:    "stdout",
+			Pointer: os.Stdout,
+			Writer:  bufio.NewWriter(os.Stdout),
+		}
+
+		return
+	}
+
+	sink.file, err = NewFile(filePath, BuffSizeJsonl)
+
+	return
+}
+
+func (js *JsonlSink) Hash(_ HashEvent) error {
+	return nil // every hash is in a range
+}
+
+func (js *JsonlSink) Range(nsecRange RangeEvent) error {
+	return js.write(jsonlRange{
+		Type:       "range",
+		Time:       time.Now(),
+		Zone:       nsecRange.Domain,
+		Hash:       nsecRange.Start,
+		HashNext:   nsecRange.End,
+		Salt:       nsecRange.Salt,
+		Iterations: nsecRange.Iterations,
+		Types:      nsecRange.Types,
+		Ns:         nsecRange.NameServer,
+		Validation: nsecRange.Validation,
+	})
+}
+
+func (js *JsonlSink) Name(name NameEvent) error {
+	return js.write(jsonlName{
+		Type:  "name",
+		Time:  time.Now(),
+		Zone:  name.Domain,
+		Name:  name.Name,
+		Types: name.Types,
+	})
+}
+
+func (js *JsonlSink) Change(change ZoneChange) error {
+	return js.write(jsonlChange{
+		Type:   "change",
+		Time:   change.Time,
+		Start:  change.Start,
+		OldEnd: change.OldEnd,
+		NewEnd: change.NewEnd,
+		Ns:     change.Ns,
+	})
+}
+
+// Diff is not a part of Sink, differences are written by file --diff only.
+func (js *JsonlSink) Diff(entry DiffEntry) error {
+	return js.write(newJsonlDiff(entry))
+}
+
+func newJsonlDiff(entry DiffEntry) jsonlDiff {
+	return jsonlDiff{
+		Type:     "diff",
+		Time:     time.Now(),
+		Zone:     entry.Domain,
+		Change:   entry.Change,
+		Kind:     entry.Kind,
+		Hash:     entry.Hash,
+		Name:     entry.Name,
+		OldTypes: entry.OldTypes,
+		NewTypes: entry.NewTypes,
+	}
+}
+
+func (js *JsonlSink) Log(message string) error {
+	return js.write(jsonlLog{
+		Type:    "log",
+		Time:    time.Now(),
+		Message: message,
+	})
+}
+
+func (js *JsonlSink) Progress(progress ProgressEvent) error {
+	return js.write(jsonlProgress{
+		Type:                 "progress",
+		Time:                 time.Now(),
+		Zone:                 progress.Domain,
+		Queries:              progress.Queries,
+		Hashes:               progress.Hashes,
+		QueriesWithoutResult: progress.QueriesWithoutResult,
+		ElapsedSec:           int64(progress.Elapsed.Seconds()),
+	})
+}
+
+func (js *JsonlSink) Flush() error {
+	return js.file.Flush()
+}
+
+func (js *JsonlSink) Close() error {
+	if js.file.Pointer == os.Stdout {
+		return js.file.Flush()
+	}
+
+	return js.file.Close()
+}
+
+func (js *JsonlSink) write(object any) (err error) {
+	line := bytes.Buffer{}
+	encoder := json.NewEncoder(&line)
+	encoder.SetEscapeHTML(false) // log messages are full of "->"
+
+	err = encoder.Encode(object) // adds the newline
+
+	if err == nil {
+		err = js.file.Write(line.String())
+	}
+
+	return
+}
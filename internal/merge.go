@@ -0,0 +1,220 @@
+package nsec3walker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Merge joins CSV files of partial walks of the same zone, e.g. from more machines.
+type Merge struct {
+	cnf          *Config
+	out          *Output
+	ranges       *RangeIndex
+	nsec         Nsec3Params
+	items        []CsvItem
+	positions    map[string]int // range start => index in items
+	origins      map[string]int // range start => index of the input file it came from
+	names        map[string]bool
+	hashes       []string
+	cntConflicts int
+}
+
+func NewMerge(config *Config) (merge *Merge) {
+	merge = &Merge{
+		cnf:       config,
+		out:       config.Output,
+		ranges:    NewRangeIndex(),
+		positions: make(map[string]int),
+		origins:   make(map[string]int),
+		names:     make(map[string]bool),
+	}
+
+	return
+}
+
+func (m *Merge) Run() (err error) {
+	prefix, err := getAbsolutePath(m.cnf.mergePrefix)
+
+	if err != nil {
+		return
+	}
+
+	for _, suffix := range []string{SuffixCsv, SuffixHash} {
+		if _, errStat := os.Stat(prefix + suffix); errStat == nil {
+			return fmt.Errorf("%s already exists, choose another --%s", prefix+suffix, FlagOutput)
+		}
+	}
+
+	for idx, filePath := range m.cnf.mergeFiles {
+		err = m.load(idx, filePath)
+
+		if err != nil {
+			return
+		}
+	}
+
+	err = m.write(prefix)
+
+	if err != nil {
+		return
+	}
+
+	msg := "Merged %d files into %s.[csv,hash]: %d ranges, %d hashes, %d conflicts"
+	m.out.Logf(msg, len(m.cnf.mergeFiles), prefix, len(m.items)-len(m.names), len(m.hashes), m.cntConflicts)
+
+	if len(m.names) > 0 {
+		// names of NSEC walks have no ranges, they don't tell whether that chain is complete
+		m.out.Logf("Merged %d names of NSEC walks", len(m.names))
+	}
+
+	if m.ranges.Len() == 0 {
+		return
+	}
+
+	if m.ranges.isFinished() {
+		m.out.Log("Merged chain is complete")
+	} else {
+		m.out.Logf("Merged chain is incomplete, %d gaps left", len(m.ranges.Gaps(0)))
+	}
+
+	return
+}
+
+func (m *Merge) load(idx int, filePath string) (err error) {
+	csv, err := NewCsv(filePath, m.out)
+
+	if err != nil {
+		return
+	}
+
+	defer csv.FileInput.Resource.Close()
+
+	err = csv.ForEach(func(csvItem CsvItem) error {
+		return m.add(idx, csvItem)
+	})
+
+	if err != nil {
+		err = fmt.Errorf("can't merge %s: %w", filePath, err)
+	}
+
+	return
+}
+
+func (m *Merge) add(idx int, csvItem CsvItem) (err error) {
+	if csvItem.Hash == "" {
+		name := normalizeDomain(csvItem.Plaintext)
+
+		if !m.names[name] {
+			m.names[name] = true
+			m.items = append(m.items, csvItem)
+		}
+
+		return
+	}
+
+	err = m.checkParams(csvItem)
+
+	if err != nil {
+		return
+	}
+
+	startExists, endExists, isFull, err := m.ranges.Add(csvItem.Hash, csvItem.HashNext)
+
+	var changeErr *RangeChangeError
+
+	if errors.As(err, &changeErr) {
+		m.cntConflicts++
+		msg := "Conflict: range starting %s ends with %s in %s, but with %s in %s"
+		origin := m.cnf.mergeFiles[m.origins[changeErr.Start]]
+		m.out.Logf(msg, changeErr.Start, changeErr.OldEnd, origin, changeErr.NewEnd, m.cnf.mergeFiles[idx])
+
+		return nil // the first one is kept
+	}
+
+	if !startExists {
+		m.hashes = append(m.hashes, csvItem.Hash)
+	}
+
+	if !endExists {
+		m.hashes = append(m.hashes, csvItem.HashNext)
+	}
+
+	if isFull {
+		m.positions[csvItem.Hash] = len(m.items)
+		m.origins[csvItem.Hash] = idx
+		m.items = append(m.items, csvItem)
+
+		return
+	}
+
+	// the same range from another input, it could be cracked or validated there
+	existing := &m.items[m.positions[csvItem.Hash]]
+
+	if existing.Plaintext == "" {
+		existing.Plaintext = csvItem.Plaintext
+	}
+
+	if existing.Validation == "" {
+		existing.Validation = csvItem.Validation
+	}
+
+	return
+}
+
+func (m *Merge) checkParams(csvItem CsvItem) (err error) {
+	if m.nsec.domain == "" {
+		m.nsec, err = NewNsec3Params(normalizeDomain(csvItem.Domain), csvItem.Salt, csvItem.Iterations)
+
+		return
+	}
+
+	sameDomain := normalizeDomain(csvItem.Domain) == m.nsec.domain
+	sameParams := strings.EqualFold(csvItem.Salt, m.nsec.saltString) && csvItem.Iterations == int(m.nsec.iterations)
+
+	if !sameDomain || !sameParams {
+		msg := "inputs differ, [%s] with salt [%s] and [%d] iterations vs [%s] with [%s] and [%d]"
+		err = fmt.Errorf(msg, m.nsec.domain, m.nsec.saltString, m.nsec.iterations, csvItem.Domain, csvItem.Salt, csvItem.Iterations)
+	}
+
+	return
+}
+
+func (m *Merge) write(prefix string) (err error) {
+	fileCsv, err := NewFile(prefix+SuffixCsv, BuffSizeCsv)
+
+	if err != nil {
+		return
+	}
+
+	defer fileCsv.Close()
+
+	fileHash, err := NewFile(prefix+SuffixHash, BuffSizeHash)
+
+	if err != nil {
+		return
+	}
+
+	defer fileHash.Close()
+
+	err = writeCsvHeader(fileCsv)
+
+	for _, item := range m.items {
+		if err != nil {
+			return
+		}
+
+		err = fileCsv.Write(item.toCsv() + "\n")
+	}
+
+	for _, hash := range m.hashes {
+		if err != nil {
+			return
+		}
+
+		err = fileHash.Write(hashToHashcat(NewHashEvent(hash, m.nsec)) + "\n")
+	}
+
+	return
+}
@@ -0,0 +1,236 @@
+package nsec3walker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	MonitorInterval = 24 * time.Hour
+	WebhookTimeout  = 30 * time.Second
+)
+
+// Monitor re-walks the zone on a schedule. Every walk is a snapshot in the state directory,
+// complete snapshots are compared with the previous one and the differences are emitted as events.
+type Monitor struct {
+	cnf     *Config
+	out     *Output
+	events  *JsonlSink
+	client  *http.Client
+	pending []DiffEntry
+	queued  []MonitorPayload // webhook payloads not delivered yet, retried after every snapshot
+}
+
+// MonitorPayload is posted to the webhook after every snapshot with changes
+type MonitorPayload struct {
+	Zone     string      `json:"zone"`
+	Time     time.Time   `json:"time"`
+	Previous string      `json:"previous"`
+	Snapshot string      `json:"snapshot"`
+	Changes  []jsonlDiff `json:"changes"`
+}
+
+func NewMonitor(config *Config) (monitor *Monitor, err error) {
+	monitor = &Monitor{
+		cnf:    config,
+		out:    config.Output,
+		client: &http.Client{Timeout: WebhookTimeout},
+	}
+
+	monitor.events, err = NewJsonlSink(monitor.statePath(SuffixEvents))
+
+	return
+}
+
+// RunMonitor walks the zone every interval, until the context is cancelled.
+func (nw *NSec3Walker) RunMonitor(ctx context.Context) (err error) {
+	monitor, err := NewMonitor(nw.config)
+
+	if err != nil {
+		return
+	}
+
+	defer monitor.events.Close()
+
+	nw.out.Logf("Monitoring [%s] every %v, snapshots in %s", nw.config.Domain, nw.config.Interval, nw.config.StateDir)
+
+	for {
+		timeStart := time.Now()
+		monitor.snapshot(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(timeStart.Add(nw.config.Interval))):
+		}
+	}
+}
+
+func (m *Monitor) snapshot(ctx context.Context) {
+	defer m.deliverWebhooks()
+
+	config := m.cnf.forZone(m.cnf.Domain)
+	err := config.setZoneOutput(m.cnf.StateDir)
+
+	if err != nil {
+		m.out.Log("Can't create the snapshot: " + err.Error())
+
+		return
+	}
+
+	zone := NewNSec3Walker(config)
+	result := zone.result(zone.RunWalk(ctx))
+	config.Output.Close()
+
+	if result.Status != ZoneStatusDone {
+		if result.Err != nil {
+			m.out.Log(result.Err.Error())
+		}
+
+		m.out.Logf("Snapshot %s is %s, it is not compared", config.filePathPrefix, result.Status)
+
+		return
+	}
+
+	// after a rollover the files of the zone have the old chain, the complete one has its own
+	previous, err := m.readLatest()
+
+	if err == nil && previous != "" {
+		err = m.compare(previous, result.Prefix)
+	}
+
+	if err == nil {
+		err = os.WriteFile(m.statePath(SuffixLatest), []byte(result.Prefix+"\n"), PermFile)
+	}
+
+	if err != nil {
+		m.out.Log(err.Error())
+	}
+}
+
+// compare diffs the snapshot with the previous one, plaintexts known before are written into the new CSV
+// file first, so names cracked once are carried over every following snapshot, even across salt changes.
+func (m *Monitor) compare(previous string, snapshot string) (err error) {
+	diff := NewDiff(m.cnf, previous+SuffixCsv, snapshot+SuffixCsv)
+	diff.onEntry = m.addEvent
+	m.pending = nil
+
+	err = diff.Run()
+
+	if err != nil {
+		return
+	}
+
+	carried := diff.carriedNames()
+
+	if carried.Count() > 0 {
+		err = m.carryNames(snapshot+SuffixCsv, carried)
+	}
+
+	if err == nil && len(m.pending) > 0 {
+		m.out.check(m.events.Flush())
+		m.queueWebhook(previous, snapshot)
+	}
+
+	return
+}
+
+func (m *Monitor) addEvent(entry DiffEntry) {
+	m.pending = append(m.pending, entry)
+	m.out.check(m.events.Diff(entry))
+}
+
+func (m *Monitor) carryNames(csvPath string, cracked *Cracked) (err error) {
+	csv, err := NewCsv(csvPath, m.out)
+
+	if err == nil {
+		err = NewCsvUpdateForData(m.cnf, csv, cracked).Run()
+	}
+
+	if err == nil {
+		m.out.Logf("Carried %d known plaintexts over into %s", cracked.Count(), csvPath)
+	}
+
+	return
+}
+
+func (m *Monitor) queueWebhook(previous string, snapshot string) {
+	if m.cnf.Webhook == "" {
+		return
+	}
+
+	payload := MonitorPayload{
+		Zone:     m.cnf.Domain,
+		Time:     time.Now(),
+		Previous: filepath.Base(previous),
+		Snapshot: filepath.Base(snapshot),
+	}
+
+	for _, entry := range m.pending {
+		payload.Changes = append(payload.Changes, newJsonlDiff(entry))
+	}
+
+	m.queued = append(m.queued, payload)
+}
+
+// deliverWebhooks posts the queued payloads in order. A failure is only logged, the snapshot is compared
+// already and its events are written, so the payload and the ones after it are retried after the next snapshot.
+func (m *Monitor) deliverWebhooks() {
+	for len(m.queued) > 0 {
+		err := m.postWebhook(m.queued[0])
+
+		if err != nil {
+			m.out.Logf("%v, %d payloads are retried after the next snapshot", err, len(m.queued))
+
+			return
+		}
+
+		m.queued = m.queued[1:]
+	}
+}
+
+func (m *Monitor) postWebhook(payload MonitorPayload) (err error) {
+	body, err := json.Marshal(payload)
+
+	if err != nil {
+		return
+	}
+
+	resp, err := m.client.Post(m.cnf.Webhook, "application/json", bytes.NewReader(body))
+
+	if err != nil {
+		return fmt.Errorf("webhook failed: %w", err)
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook failed: %s", resp.Status)
+	}
+
+	return
+}
+
+// readLatest returns the prefix of the last complete snapshot, empty for the first one.
+func (m *Monitor) readLatest() (prefix string, err error) {
+	data, err := os.ReadFile(m.statePath(SuffixLatest))
+
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+
+	prefix = strings.TrimSpace(string(data))
+
+	return
+}
+
+func (m *Monitor) statePath(suffix string) string {
+	return filepath.Join(m.cnf.StateDir, normalizeDomain(m.cnf.Domain)+suffix)
+}
@@ -0,0 +1,87 @@
+package nsec3walker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// NameServer is a single address of an authoritative NS server, anycast or multi-homed
+// servers are walked per address.
+type NameServer struct {
+	Name    string
+	Address string // ip:port
+}
+
+func (ns NameServer) String() string {
+	host, _ := splitServer(ns.Name)
+
+	if net.ParseIP(host) != nil {
+		return ns.Address
+	}
+
+	return host + "/" + ns.Address
+}
+
+// resolveNameServers expands NS servers into all of their IPv4 and IPv6 addresses, lookups stop with the ctx.
+func (cnf *Config) resolveNameServers(ctx context.Context) (err error) {
+	cnf.NameServers = nil
+
+	for _, server := range cnf.DomainDnsServers {
+		host, port := splitServer(server)
+		ips, errLookup := lookupIps(ctx, host)
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if errLookup != nil {
+			cnf.Output.Logf("Can't resolve NS server [%s]: %v", host, errLookup)
+
+			continue
+		}
+
+		for _, ip := range ips {
+			isIpv4 := ip.To4() != nil
+
+			if cnf.Ipv4Only && !isIpv4 || cnf.Ipv6Only && isIpv4 {
+				continue
+			}
+
+			address := net.JoinHostPort(ip.String(), port)
+			cnf.NameServers = append(cnf.NameServers, NameServer{Name: server, Address: address})
+		}
+	}
+
+	if len(cnf.NameServers) == 0 {
+		err = fmt.Errorf("no usable addresses of NS servers for domain %s", cnf.Domain)
+	}
+
+	return
+}
+
+func lookupIps(ctx context.Context, host string) (ips []net.IP, err error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+
+	return
+}
+
+// splitServer splits host and port, bare IPv6 addresses get the default DNS port.
+func splitServer(server string) (host string, port string) {
+	host, port, err := net.SplitHostPort(server)
+
+	if err != nil {
+		return strings.Trim(server, "[]"), DnsPort
+	}
+
+	return
+}
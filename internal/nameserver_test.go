@@ -0,0 +1,21 @@
+package nsec3walker
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestResolveNameServersCanceled checks lookups of NS servers stop with the walk
+func TestResolveNameServersCanceled(t *testing.T) {
+	config := NewWalkConfig("example.com")
+	config.Output.SetLogger(func(string) {})
+	config.DomainDnsServers = []string{"ns1.example.com", "ns2.example.com"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := config.resolveNameServers(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("resolved with error %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,172 @@
+package nsec3walker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const NsecMaxFailures = 10 // consecutive failures per NS before giving up on the chain
+
+var errNoNsec = errors.New("no NSEC record for the name")
+
+// isNsecZone checks if any NS server has NSEC record for the zone apex.
+func (nw *NSec3Walker) isNsecZone() bool {
+	apex := dns.Fqdn(nw.nsec.domain)
+
+	for _, ns := range nw.config.NameServers {
+		if _, err := nw.getNsecRecord(apex, ns.Address); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runNsecWalk follows NextDomain of NSEC records from the apex, until the chain wraps to the apex again.
+// Names are in cleartext, so the walk is sequential. With --resume it continues from the last name of the CSV file.
+func (nw *NSec3Walker) runNsecWalk(ctx context.Context) (err error) {
+	nw.out.Log("Zone [" + nw.nsec.domain + "] is signed with NSEC, walking the NSEC chain")
+
+	if nw.config.FillGaps != "" {
+		return fmt.Errorf("--%s needs a zone signed with NSEC3, [%s] is signed with NSEC", FlagFillGaps, nw.nsec.domain)
+	}
+
+	apex := strings.ToLower(dns.Fqdn(nw.nsec.domain))
+	name := apex
+	written := make(map[string]bool)
+
+	if nw.config.Resume {
+		var last string
+		written, last, err = nw.resumeNsecFromCsv(nw.config.filePathPrefix + SuffixCsv)
+
+		if err != nil {
+			return
+		}
+
+		if last != "" {
+			name = last
+		}
+
+		nw.stats.hashes.Store(int64(len(written)))
+	}
+
+	servers := nw.config.NameServers
+	limiters := make(map[NameServer]*RateLimiter)
+
+	for _, ns := range servers {
+		limiters[ns] = NewRateLimiter(nw.config.QpsInitial, nw.config.QpsMin, nw.config.QpsMax)
+		nw.stats.addRateLimiter(ns.String(), limiters[ns])
+	}
+
+	ctx, nw.cancel = context.WithCancelCause(ctx)
+	defer nw.cancel(nil)
+
+	interval := time.Second * time.Duration(nw.config.LogCounterIntervalSec)
+	go nw.stats.logCounterChanges(ctx, nw.cancel, interval, nw.config.QuitAfterMin)
+
+	seen := make(map[string]bool)
+	cntFailures := 0
+	idxNs := 0
+
+	for err == nil && ctx.Err() == nil {
+		ns := servers[idxNs%len(servers)]
+
+		if !limiters[ns].Wait(ctx) {
+			break
+		}
+
+		nsec, errNs := nw.getNsecRecord(name, ns.Address)
+		nw.stats.didQuery()
+
+		if errNs != nil {
+			limiters[ns].Failure()
+			nw.out.Logf("Error getting NSEC for [%s] from [%s]: %v", name, ns, errNs)
+			idxNs++ // try the next NS
+			cntFailures++
+
+			if cntFailures >= NsecMaxFailures*len(servers) {
+				err = fmt.Errorf("can't continue NSEC walk at [%s]: %w", name, errNs)
+			}
+
+			continue
+		}
+
+		limiters[ns].Success()
+		cntFailures = 0
+		next := strings.ToLower(nsec.NextDomain)
+
+		if strings.HasPrefix(next, "\\000") {
+			nw.out.Log(fmt.Sprintf("Black lies from [%s]", ns))
+			err = ErrBlackLies
+
+			break
+		}
+
+		seen[name] = true
+
+		if !written[name] {
+			nw.out.NsecName(strings.TrimSuffix(name, "."), nsec.TypeBitMap, nw.nsec)
+			nw.emitName(strings.TrimSuffix(name, "."), nsec.TypeBitMap)
+			err = nw.out.Err()
+			nw.stats.gotHash(true, false) // a new name counts as one hash, resumed ones are no progress
+		}
+
+		if next == apex {
+			nw.cancel(errWalkFinished)
+
+			break
+		}
+
+		if seen[next] {
+			err = fmt.Errorf("NSEC chain loops back to [%s] instead of the apex", next)
+
+			break
+		}
+
+		name = next
+	}
+
+	if err == nil {
+		err = nw.stopReason(ctx)
+	}
+
+	nw.stats.logSummary()
+	nw.out.Flush()
+
+	return
+}
+
+// getNsecRecord returns NSEC record owned by the name.
+func (nw *NSec3Walker) getNsecRecord(name string, ns string) (nsec *dns.NSEC, err error) {
+	// Delegations don't answer NSEC themselves, but the parent has NSEC in a referral or in a DS denial.
+	// The last resort is a name right after the current one, which is covered by the current NSEC.
+	queries := []struct {
+		name    string
+		dnsType uint16
+	}{
+		{name, dns.TypeNSEC},
+		{name, dns.TypeDS},
+		{"\\000." + name, dns.TypeA},
+	}
+
+	for _, query := range queries {
+		r, errQuery := nw.client.getDnsResponse(query.name, ns, query.dnsType)
+
+		if errQuery != nil {
+			return nil, errQuery
+		}
+
+		for _, rr := range append(r.Answer, r.Ns...) {
+			if nsecRr, ok := rr.(*dns.NSEC); ok && strings.EqualFold(nsecRr.Header().Name, name) {
+				return nsecRr, nil
+			}
+		}
+	}
+
+	return nil, errNoNsec
+}
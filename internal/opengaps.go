@@ -0,0 +1,207 @@
+package nsec3walker
+
+import (
+	"cmp"
+	"container/heap"
+	"iter"
+	"slices"
+)
+
+const (
+	GapsKept    = 1 << 20 // largest open gaps kept with their size in memory, about 80 MB
+	GapsKeptTop = 4 * GapTargets
+)
+
+// openGaps counts open hashes of the index, sizes of their gaps are kept only for the largest ones,
+// so memory doesn't grow with the walk. None of the gaps which are not kept is larger than floor,
+// when too few kept gaps are above it, they are all found again by a pass over the ranges.
+// The largest few of the kept gaps are tracked the same way in top, so the gap strategy
+// gets its targets without going through all the kept gaps every time.
+type openGaps struct {
+	cnt     int // open hashes, kept or not
+	limit   int // how many gaps are kept
+	floor   float64
+	kept    keptGaps
+	top     *openGaps
+	version uint64 // changes with every gap opened, closed or resized
+}
+
+// openGap is a Gap by its open hash, the end is looked up only for the gaps returned
+type openGap struct {
+	start HashDigest
+	size  float64
+}
+
+// newOpenGaps keeps limit of the largest gaps, and topLimit of them in top, no top without topLimit
+func newOpenGaps(limit int, topLimit int) (og *openGaps) {
+	og = &openGaps{
+		limit: limit,
+		kept:  keptGaps{pos: make(map[HashDigest]int)},
+	}
+
+	if topLimit > 0 {
+		og.top = newOpenGaps(topLimit, 0)
+	}
+
+	return
+}
+
+// add counts the open hash, its gap is kept if it is among the largest ones
+func (og *openGaps) add(start HashDigest, size float64) {
+	og.cnt++
+	og.version++
+	gap := openGap{start: start, size: size}
+
+	if og.top != nil {
+		og.top.add(start, size)
+	}
+
+	if og.kept.Len() < og.limit {
+		heap.Push(&og.kept, gap)
+
+		return
+	}
+
+	if og.kept.Len() > 0 && size > og.kept.gaps[0].size {
+		gap, og.kept.gaps[0] = og.kept.gaps[0], gap
+		delete(og.kept.pos, gap.start)
+		og.kept.pos[start] = 0
+		heap.Fix(&og.kept, 0)
+	}
+
+	og.floor = max(og.floor, gap.size)
+}
+
+func (og *openGaps) remove(start HashDigest) {
+	og.cnt--
+	og.version++
+
+	if og.top != nil {
+		og.top.remove(start)
+	}
+
+	if i, ok := og.kept.pos[start]; ok {
+		heap.Remove(&og.kept, i)
+	}
+}
+
+func (og *openGaps) isAllKept() bool {
+	return og.kept.Len() == og.cnt
+}
+
+// largest returns up to limit of the kept gaps, largest first, ok is false if gaps which are not kept could be larger.
+// The top gaps are used when they have them, else they are filled again from the kept ones.
+func (og *openGaps) largest(limit int) (gaps []openGap, ok bool) {
+	if og.top != nil && limit > 0 && limit <= og.top.limit {
+		gaps, ok = og.top.largest(limit)
+
+		if !ok && og.fillTop() {
+			gaps, ok = og.top.largest(limit)
+		}
+
+		if ok {
+			return
+		}
+	}
+
+	return og.largestKept(limit)
+}
+
+// fillTop puts the largest of the kept gaps into top, false if the kept gaps don't have the largest ones
+func (og *openGaps) fillTop() bool {
+	gaps, ok := og.largestKept(og.top.limit)
+
+	if !ok {
+		return false
+	}
+
+	og.top = newOpenGaps(og.top.limit, 0)
+	og.top.cnt = og.cnt
+
+	for _, gap := range gaps {
+		heap.Push(&og.top.kept, gap)
+	}
+
+	if len(gaps) == og.top.limit {
+		og.top.floor = gaps[len(gaps)-1].size // the rest of the kept gaps and the ones not kept are not larger
+	}
+
+	return true
+}
+
+func (og *openGaps) largestKept(limit int) (gaps []openGap, ok bool) {
+	gaps = largestGaps(slices.Values(og.kept.gaps), limit)
+
+	if og.isAllKept() {
+		return gaps, true
+	}
+
+	ok = limit > 0 && len(gaps) == limit && gaps[limit-1].size >= og.floor
+
+	return
+}
+
+// largestGaps returns up to limit gaps of the sequence, largest first, all of them without a limit.
+// With a limit only the largest gaps are kept while collecting them.
+func largestGaps(gaps iter.Seq[openGap], limit int) []openGap {
+	largest := &gapHeap{}
+
+	for gap := range gaps {
+		if limit <= 0 || largest.Len() < limit {
+			heap.Push(largest, gap)
+		} else if gap.size > (*largest)[0].size {
+			(*largest)[0] = gap
+			heap.Fix(largest, 0)
+		}
+	}
+
+	slices.SortFunc(*largest, func(a, b openGap) int {
+		return cmp.Compare(b.size, a.size)
+	})
+
+	return *largest
+}
+
+// gapHeap keeps the smallest gap on top, to be replaced by a larger one
+type gapHeap []openGap
+
+func (h gapHeap) Len() int           { return len(h) }
+func (h gapHeap) Less(i, j int) bool { return h[i].size < h[j].size }
+func (h gapHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *gapHeap) Push(x any)        { *h = append(*h, x.(openGap)) }
+
+func (h *gapHeap) Pop() (x any) {
+	old := *h
+	x = old[len(old)-1]
+	*h = old[:len(old)-1]
+	return
+}
+
+// keptGaps is gapHeap with positions of the gaps, so a closed gap can be removed from it
+type keptGaps struct {
+	gaps []openGap
+	pos  map[HashDigest]int // start of the gap => index in gaps
+}
+
+func (h *keptGaps) Len() int           { return len(h.gaps) }
+func (h *keptGaps) Less(i, j int) bool { return h.gaps[i].size < h.gaps[j].size }
+
+func (h *keptGaps) Swap(i, j int) {
+	h.gaps[i], h.gaps[j] = h.gaps[j], h.gaps[i]
+	h.pos[h.gaps[i].start] = i
+	h.pos[h.gaps[j].start] = j
+}
+
+func (h *keptGaps) Push(x any) {
+	gap := x.(openGap)
+	h.pos[gap.start] = len(h.gaps)
+	h.gaps = append(h.gaps, gap)
+}
+
+func (h *keptGaps) Pop() (x any) {
+	gap := h.gaps[len(h.gaps)-1]
+	h.gaps = h.gaps[:len(h.gaps)-1]
+	delete(h.pos, gap.start)
+
+	return gap
+}
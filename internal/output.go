@@ -4,100 +4,107 @@ import (
 	"fmt"
 	"github.com/miekg/dns"
 	"log"
+	"sync"
 )
 
-type OutputFiles struct {
-	HashFile *File
-	LogFile  *File
-	MapFile  *File
-}
-
 type Output struct {
-	files   *OutputFiles
-	verbose bool
+	files    *OutputFiles
+	sinks    []Sink
+	cntOwned int // sinks from this one on are closed by this output, the ones before are shared with the parent
+	verbose  bool
+	tag      string // prefix of log lines, when more zones are walked at once
+	silent   bool   // no hashes on stdout without output files, for embedding
+	logger   func(message string)
+	failed   *writeError
 }
 
-func NewFiles(fileAbs string) (files *OutputFiles, err error) {
-	files = &OutputFiles{}
+// writeError keeps the first failed write, it is shared by the output and its children.
+type writeError struct {
+	mutex sync.Mutex
+	err   error
+}
 
-	files.HashFile, err = NewFile(fileAbs+SuffixHash, BuffSizeHash)
+func NewOutput() (output *Output) {
+	return &Output{failed: &writeError{}}
+}
 
-	if err != nil {
-		return
+// NewChild returns output for a zone walked next to others, it shares sinks except the output files.
+func (o *Output) NewChild(tag string) (child *Output) {
+	child = &Output{
+		verbose: o.verbose,
+		tag:     tag,
+		silent:  o.silent,
+		logger:  o.logger,
+		failed:  o.failed,
 	}
 
-	files.MapFile, err = NewFile(fileAbs+SuffixCsv, BuffSizeCsv)
-
-	if err != nil {
-		_ = files.HashFile.Close()
-
-		return
+	for _, sink := range o.sinks {
+		if sink != Sink(o.files) {
+			child.sinks = append(child.sinks, sink)
+		}
 	}
 
-	files.LogFile, err = NewFile(fileAbs+SuffixLog, 0)
-
-	if err != nil {
-		_ = files.HashFile.Close()
-		_ = files.MapFile.Close()
-	}
+	child.cntOwned = len(child.sinks)
 
 	return
 }
 
-func NewOutput() (output *Output) {
-	return &Output{}
-}
-
 func (o *Output) SetVerbose(verbose bool) {
 	o.verbose = verbose
 }
 
-func (o *Output) SetFilePrefix(filePrefix string) (err error) {
-	o.files, err = NewFiles(filePrefix)
+func (o *Output) SetSilent(silent bool) {
+	o.silent = silent
+}
 
-	return
+// SetLogger sends log lines to the logger instead of the standard log, sinks get them either way.
+func (o *Output) SetLogger(logger func(message string)) {
+	o.logger = logger
 }
 
-func (fi *OutputFiles) Close() {
-	if fi.HashFile != nil {
-		_ = fi.HashFile.Close()
-	}
+func (o *Output) SetTag(tag string) {
+	o.tag = tag
+}
 
-	if fi.MapFile != nil {
-		_ = fi.MapFile.Close()
-	}
+func (o *Output) SetFilePrefix(filePrefix string) (err error) {
+	o.files, err = NewFiles(filePrefix)
 
-	if fi.LogFile != nil {
-		_ = fi.LogFile.Close()
+	if err == nil {
+		o.AddSink(o.files)
 	}
+
+	return
 }
 
-func (o *Output) Hash(hash string, nsec Nsec3Params) {
-	msg := fmt.Sprintf("%s:.%s:%s:%d\n", hash, nsec.domain, nsec.saltString, nsec.iterations)
+func (o *Output) AddSink(sink Sink) {
+	o.sinks = append(o.sinks, sink)
+}
 
-	if !o.isFileOutput() {
-		fmt.Print(msg)
+func (o *Output) Hash(hash string, nsec Nsec3Params) {
+	event := NewHashEvent(hash, nsec)
 
-		return
+	if !o.isFileOutput() && !o.silent {
+		fmt.Println(hashToHashcat(event))
 	}
 
-	err := o.files.HashFile.Write(msg)
-
-	if err != nil {
-		log.Fatal(err)
+	for _, sink := range o.sinks {
+		o.check(sink.Hash(event))
 	}
 }
 
 func (o *Output) Log(message string) {
-	log.Println(message)
-
-	if o.isFileOutput() {
-		err := o.files.LogFile.Write(message + "\n")
+	if o.tag != "" {
+		message = "[" + o.tag + "] " + message
+	}
 
-		if err != nil {
-			log.Fatal(err)
-		}
+	if o.logger != nil {
+		o.logger(message)
+	} else {
+		log.Println(message)
+	}
 
+	for _, sink := range o.sinks {
+		o.check(sink.Log(message))
 	}
 }
 
@@ -108,46 +115,100 @@ func (o *Output) LogVerbose(message string) {
 }
 
 func (o *Output) Fatal(err error) {
+	o.Failed(err)
+	o.Close() // log.Fatal skips deferred calls, buffered output would be lost
 	log.Fatal(err)
 }
 
+// Failed writes the error a walk stopped on into the sinks, the caller reports it.
+func (o *Output) Failed(err error) {
+	for _, sink := range o.sinks {
+		_ = sink.Log(err.Error())
+	}
+}
+
 func (o *Output) isFileOutput() bool {
 	return o.files != nil
 }
 
-func (o *Output) Csv(hash Nsec3Record, nsec Nsec3Params) {
-	if !o.isFileOutput() {
+// check keeps the first failed write, the walk stops on it as the output would be incomplete
+func (o *Output) check(err error) {
+	if err == nil {
 		return
 	}
 
-	var types []string
+	o.failed.mutex.Lock()
+	defer o.failed.mutex.Unlock()
 
-	for _, t := range hash.Types {
-		types = append(types, dns.TypeToString[t])
+	if o.failed.err == nil {
+		o.failed.err = fmt.Errorf("can't write output: %w", err)
 	}
+}
 
-	csvItem := CsvItem{
-		Hash:       hash.Start,
-		HashNext:   hash.End,
-		Domain:     nsec.domain,
-		Salt:       nsec.saltString,
-		Iterations: int(nsec.iterations),
-		Plaintext:  "",
-		Types:      types,
+// Err returns the first failed write of the output or any of its children.
+func (o *Output) Err() error {
+	o.failed.mutex.Lock()
+	defer o.failed.mutex.Unlock()
+
+	return o.failed.err
+}
+
+func (o *Output) Csv(hash Nsec3Record, nsec Nsec3Params) {
+	event := NewRangeEvent(hash, nsec)
+
+	for _, sink := range o.sinks {
+		o.check(sink.Range(event))
 	}
+}
 
-	msg := csvItem.toCsv()
+func (o *Output) Progress(progress ProgressEvent) {
+	for _, sink := range o.sinks {
+		o.check(sink.Progress(progress))
+	}
+}
 
-	err := o.files.MapFile.Write(msg + "\n")
+func (o *Output) Flush() {
+	for _, sink := range o.sinks {
+		_ = sink.Flush()
+	}
+}
+
+// Change writes a range which changed during the walk, without file output it is logged too.
+func (o *Output) Change(change ZoneChange) {
+	if !o.isFileOutput() {
+		o.Logf("Zone changed, range %s => %s is now %s => %s | from %s",
+			change.Start, change.OldEnd, change.Start, change.NewEnd, change.Ns)
+	}
+
+	for _, sink := range o.sinks {
+		o.check(sink.Change(change))
+	}
+}
 
-	if err != nil {
-		log.Fatal(err)
+// NsecName writes a cleartext name from NSEC walk.
+func (o *Output) NsecName(name string, types []uint16, nsec Nsec3Params) {
+	if !o.isFileOutput() && !o.silent {
+		fmt.Println(name)
 	}
+
+	event := NameEvent{Domain: nsec.domain, Name: name, Types: typesToStrings(types)}
+
+	for _, sink := range o.sinks {
+		o.check(sink.Name(event))
+	}
+}
+
+func typesToStrings(types []uint16) (typesStr []string) {
+	for _, t := range types {
+		typesStr = append(typesStr, dns.TypeToString[t])
+	}
+
+	return
 }
 
 func (o *Output) Close() {
-	if o.files != nil {
-		o.files.Close()
+	for _, sink := range o.sinks[o.cntOwned:] {
+		_ = sink.Close()
 	}
 }
 
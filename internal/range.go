@@ -1,197 +1,562 @@
 package nsec3walker
 
 import (
+	"encoding/binary"
 	"fmt"
-	"log"
+	"iter"
+	"math"
 	"sync"
-	"sync/atomic"
-
-	rbt "github.com/emirpasic/gods/trees/redblacktree"
 )
 
-type HashTree struct {
-	tree  *rbt.Tree
-	mutex sync.RWMutex
+// Gap is an uncovered part of the hash space, from a hash with unknown range to the next known hash.
+type Gap struct {
+	Start string
+	End   string
+	Size  float64 // fraction of the whole hash space
+}
+
+// Overlap is a known hash inside the range from Start to End
+type Overlap struct {
+	Start string
+	End   string
+	Hash  string
 }
 
+// RangeIndex keeps ranges of one NSEC3 chain. Completeness and open hashes are tracked as ranges are added,
+// so checking whether the chain is finished doesn't walk the index.
 type RangeIndex struct {
-	index              *HashTree
-	cntEndWithoutStart atomic.Int64
-	ignoreChanges      bool
-	addMutex           sync.Mutex
+	store           rangeStore
+	open            *openGaps // hashes known only as an end of a range, with sizes of the largest gaps they start
+	cntLinked       int       // ranges ending at the next known hash, the chain is complete when all are
+	replaceOnChange bool      // keep the newest view of the zone, instead of refusing the changed range
+	cntAgree        int       // NS servers which have to report a changed range before it is replaced
+	changes         map[HashDigest]pendingChange
+	mutex           sync.RWMutex
 }
 
-func NewHashTree() (hashTree *HashTree) {
-	hashTree = &HashTree{
-		tree: rbt.NewWithStringComparator(),
-	}
-	return
+// pendingChange is a changed range reported by fewer NS servers than needed to replace the known one
+type pendingChange struct {
+	end     HashDigest
+	servers map[string]bool
+}
+
+// rangeStore keeps ranges sorted by their start, HashTree in memory or DiskTree in a file with --index-dir.
+// Lookups return false when there is no such range.
+type rangeStore interface {
+	Len() int
+	get(hash HashDigest) (hashRange, bool)
+	floor(hash HashDigest) (hashRange, bool)
+	lower(hash HashDigest) (hashRange, bool)
+	higherCyclic(hash HashDigest) (hashRange, bool)
+	max() (hashRange, bool)
+	around(hash HashDigest) hashNeighbours
+	all() iter.Seq[hashRange]
+	put(r hashRange) (isNew bool)
+	remove(hash HashDigest) (removed bool)
+	Err() error // first failure of the storage, the store can't be trusted after it
+	Close() error
+}
+
+// RangeChangeError is returned by RangeIndex.Add when a known range start has a different end now
+type RangeChangeError struct {
+	Start  string
+	OldEnd string
+	NewEnd string
+}
+
+func (e *RangeChangeError) Error() string {
+	msg := "range starting %s already exists with different hashEnd! Existing: %s | New: %s"
+
+	return fmt.Sprintf(msg, e.Start, e.OldEnd, e.NewEnd)
 }
 
 func NewRangeIndex() (rangeIndex *RangeIndex) {
+	return newRangeIndex(NewHashTree())
+}
+
+func newRangeIndex(store rangeStore) (rangeIndex *RangeIndex) {
 	rangeIndex = &RangeIndex{
-		index: NewHashTree(),
+		store:   store,
+		open:    newOpenGaps(GapsKept, GapsKeptTop),
+		changes: make(map[HashDigest]pendingChange),
 	}
 	return
 }
 
-func (ht *HashTree) Add(key, val string) {
-	ht.mutex.Lock()
-	defer ht.mutex.Unlock()
-	ht.tree.Put(key, val)
-}
+// OpenRangeIndex keeps the ranges in a DiskTree file. Only counters and the largest GapsKept gaps stay in memory.
+// With keep, ranges of an index closed cleanly by a previous walk are restored and the rest is counted again.
+func OpenRangeIndex(path string, key string, keep bool) (rangeIndex *RangeIndex, isRestored bool, err error) {
+	store, isRestored, err := OpenDiskTree(path, key, keep)
 
-func (ht *HashTree) Get(key string) (value string, exists bool) {
-	ht.mutex.RLock()
-	defer ht.mutex.RUnlock()
-	valInterface, exists := ht.tree.Get(key)
-	if exists {
-		value = valInterface.(string)
+	if err != nil {
+		return
 	}
-	return
-}
 
-func (ht *HashTree) GetLastRange() (key, val string) {
-	ht.mutex.RLock()
-	defer ht.mutex.RUnlock()
-	lastNode := ht.tree.Right()
-	if lastNode != nil {
-		return lastNode.Key.(string), lastNode.Value.(string)
+	rangeIndex = newRangeIndex(store)
+
+	if isRestored {
+		rangeIndex.relink()
+		err = store.Err()
 	}
+
+	if err != nil {
+		_ = store.Close()
+		rangeIndex = nil
+	}
+
 	return
 }
 
-func (ht *HashTree) PrintAll() {
-	ht.mutex.RLock()
-	defer ht.mutex.RUnlock()
-	iterator := ht.tree.Iterator()
-	for iterator.Next() {
-		log.Printf("Range %s => %s", iterator.Key().(string), iterator.Value().(string))
-	}
-}
-
-func (ht *HashTree) allRangesComplete() bool {
-	ht.mutex.RLock()
-	defer ht.mutex.RUnlock()
-	iterator := ht.tree.Iterator()
-	firstHash := ""
-	lastEndHash := ""
-	for iterator.Next() {
-		startHash := iterator.Key().(string)
-		endHash := iterator.Value().(string)
-		if firstHash == "" {
-			firstHash = startHash
-		} else if startHash != lastEndHash {
-			return false
-		}
-		if endHash == "" {
-			return false
+// relink counts the linked ranges and gaps of the open hashes in one pass over the ranges
+func (ri *RangeIndex) relink() {
+	var first, prev hashRange
+	var cnt int
+
+	for r := range ri.store.all() {
+		if cnt == 0 {
+			first = r
+		} else {
+			ri.cntLinked += ri.link(prev, r.start)
 		}
-		lastEndHash = endHash
+
+		prev = r
+		cnt++
+	}
+
+	if cnt > 0 {
+		ri.cntLinked += ri.link(prev, first.start)
 	}
-	// if we get here all ranges have an end
-	// we just need to check the last range wraps around (which it will unless the last hash is zzzzzzzzzzzzz)
-	return lastEndHash == firstHash
 }
 
-// returns range that starts with the largest key that is less than the input hash
-func (ht *HashTree) ClosestBefore(input string) (startHash string, endHash string, found bool) {
-	// TODO: Why cant we use range and built in iterator?
-	ht.mutex.RLock()
-	defer ht.mutex.RUnlock()
-	iterator := ht.tree.Iterator()
-	for iterator.Next() {
-		if iterator.Key().(string) >= input {
-			break
-		}
+func isBetween(hash HashDigest, start HashDigest, end HashDigest) bool {
+	if compareHash(start, end) < 0 {
+		return compareHash(start, hash) < 0 && compareHash(hash, end) < 0
 	}
-	if iterator.Prev() {
-		startHash = iterator.Key().(string)
-		endHash = iterator.Value().(string)
-		found = true
+	return compareHash(hash, start) > 0 || compareHash(hash, end) < 0 // range wraps around
+}
+
+// gapSize returns the fraction of the hash space from start to end
+func gapSize(start HashDigest, end HashDigest) (size float64) {
+	size = hashToFraction(end) - hashToFraction(start)
+	if size <= 0 {
+		size++ // wraps around the end of the hash space
 	}
 	return
 }
 
-func (ri *RangeIndex) PrintAll() {
-	ri.index.PrintAll()
+// hashToFraction returns position of the hash in the hash space, from 0 to 1
+func hashToFraction(hash HashDigest) float64 {
+	return float64(binary.BigEndian.Uint64(hash[:8])) / math.Pow(2, 64)
+}
+
+func encodeHash(hash HashDigest) string {
+	return hashEncoding.EncodeToString(hash[:])
 }
 
 func (ri *RangeIndex) Add(hashStart string, hashEnd string) (existsStart bool, existsEnd bool, setFull bool, err error) {
+	return ri.AddFrom(hashStart, hashEnd, "")
+}
+
+// AddFrom is Add of a range returned by the NS server. A changed range replaces the known one only once
+// cntAgree servers reported it, so a server lagging behind the others can't flip the range back and forth.
+// Until then the change is pending and it is returned as a known range, without RangeChangeError.
+// Ranges without the server are trusted at once.
+func (ri *RangeIndex) AddFrom(hashStart string, hashEnd string, ns string) (existsStart bool, existsEnd bool, setFull bool, err error) {
 	/**
 	If hashStart key already exists, check the value didn't change (hashEnd)
-	If hashEnd does not exists, add it with empty value
+	If hashEnd does not exists, add it as an open hash, without end
 	*/
-	ri.addMutex.Lock() // this mutex is for ensuring correct values of cntChains and cntEndWithoutStart
-	existingStartValAsStart, existsStart := ri.index.Get(hashStart)
-	_, existsEnd = ri.index.Get(hashEnd)
+	var start, end HashDigest
+	start, err = DecodeHash(hashStart)
 
-	// !existsStart = adding full chain from start to end
-	// !existsEnd adding end of chan as start with empty end
+	if err == nil {
+		end, err = DecodeHash(hashEnd)
+	}
+
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrInvalidHash, err)
+
+		return
+	}
+
+	ri.mutex.Lock()
+	defer ri.mutex.Unlock()
+
+	if err = ri.store.Err(); err != nil {
+		return
+	}
+
+	nb := ri.store.around(start)
+	existsStart = nb.hasAt
+	// in a consistent chain the end is the next known hash, if it is known already
+	existsEnd = !nb.isEmpty && nb.after.start == end
+	known := nb.at
+
+	if !existsEnd {
+		_, existsEnd = ri.store.get(end)
+	}
 
 	// existsAndDifferentEnd = start exists and end is different
-	existsAndDifferentEnd := existsStart && existingStartValAsStart != "" && existingStartValAsStart != hashEnd
+	existsAndDifferentEnd := existsStart && known.hasEnd && known.end != end
 	if existsAndDifferentEnd {
-		msg := "range starting %s already exists with different hashEnd! Existing: %s | New: %s"
-		err = fmt.Errorf(msg, hashStart, existingStartValAsStart, hashEnd)
-
-		if !ri.ignoreChanges {
-			ri.addMutex.Unlock()
+		if ri.replaceOnChange && !ri.isChangeAgreed(start, end, ns) {
+			// the known range stays until more servers report the change, so there is nothing new to report yet
+			existsEnd = true
 
 			return
 		}
-	}
 
-	// existsStartWithEmptyEnd = start exists and end is empty, from being End before
-	existsStartWithEmptyEnd := existsStart && existingStartValAsStart == ""
-	setFull = !existsStart || existsStartWithEmptyEnd
+		err = &RangeChangeError{Start: hashStart, OldEnd: encodeHash(known.end), NewEnd: hashEnd}
+
+		if !ri.replaceOnChange {
+			return
+		}
 
-	if existsStartWithEmptyEnd {
-		ri.cntEndWithoutStart.Add(-1)
+		// the newest view wins, hashes between start and the new end are gone from the zone
+		ri.removeBetween(start, end)
+		nb = ri.store.around(start)
 	}
 
+	// start exists without end, from being End before
+	setFull = !existsStart || !known.hasEnd || existsAndDifferentEnd
+
 	if setFull {
-		ri.index.Add(hashStart, hashEnd)
+		ri.setAt(hashRange{start: start, end: end, hasEnd: true}, nb)
 	}
 
-	if !existsEnd {
-		ri.cntEndWithoutStart.Add(1)
-		ri.index.Add(hashEnd, "")
+	if !existsEnd && end != start {
+		ri.set(hashRange{start: end})
 	}
 
-	ri.addMutex.Unlock()
+	if errStore := ri.store.Err(); errStore != nil {
+		err = errStore
+	}
 
 	return
 }
 
+// isChangeAgreed records the server reporting the changed range, true once enough servers reported the same end.
+func (ri *RangeIndex) isChangeAgreed(start HashDigest, end HashDigest, ns string) bool {
+	if ns == "" || ri.cntAgree < 2 {
+		return true
+	}
+
+	change, exists := ri.changes[start]
+
+	if !exists || change.end != end {
+		change = pendingChange{end: end, servers: make(map[string]bool)}
+		ri.changes[start] = change
+	}
+
+	change.servers[ns] = true
+
+	if len(change.servers) < ri.cntAgree {
+		return false
+	}
+
+	delete(ri.changes, start)
+
+	return true
+}
+
+// set adds or replaces the range. Only this range and the one before it can change being linked, ending at
+// the next known hash as in a complete chain, and only these two can change their gap, if they are open.
+func (ri *RangeIndex) set(r hashRange) {
+	ri.setAt(r, ri.store.around(r.start))
+}
+
+// setAt is set with the ranges around the start of the range, as returned by rangeStore.around
+func (ri *RangeIndex) setAt(r hashRange, nb hashNeighbours) {
+	if nb.isEmpty {
+		ri.store.put(r)
+		ri.cntLinked += ri.link(r, r.start)
+
+		return
+	}
+
+	prev, next := nb.before, nb.after
+	isOnly := prev.start == r.start
+
+	if nb.hasAt {
+		ri.cntLinked -= ri.unlink(nb.at, next.start)
+	} else if !isOnly {
+		ri.cntLinked -= ri.unlink(prev, next.start)
+	}
+
+	ri.store.put(r)
+
+	if isOnly {
+		ri.cntLinked += ri.link(r, r.start)
+
+		return
+	}
+
+	ri.cntLinked += ri.link(r, next.start)
+
+	if !nb.hasAt {
+		ri.cntLinked += ri.link(prev, r.start)
+	}
+}
+
+func (ri *RangeIndex) remove(hash HashDigest) {
+	nb := ri.store.around(hash)
+
+	if !nb.hasAt {
+		return
+	}
+
+	prev, r, next := nb.before, nb.at, nb.after
+	ri.cntLinked -= ri.unlink(r, next.start)
+	ri.store.remove(hash)
+
+	if prev.start != hash {
+		ri.cntLinked -= ri.unlink(prev, hash)
+		ri.cntLinked += ri.link(prev, next.start)
+	}
+}
+
+// removeBetween removes hashes strictly between start and end, wrapping around the end of the hash space.
+func (ri *RangeIndex) removeBetween(start HashDigest, end HashDigest) {
+	for r, ok := ri.store.higherCyclic(start); ok; r, ok = ri.store.higherCyclic(start) {
+		if !isBetween(r.start, start, end) || ri.store.Err() != nil {
+			break
+		}
+
+		ri.remove(r.start)
+	}
+}
+
+// link counts the range in, it returns 1 if the range ends at the next known hash, else 0.
+// An open range is counted with its gap up to the next known hash.
+func (ri *RangeIndex) link(r hashRange, next HashDigest) int {
+	if !r.hasEnd {
+		ri.open.add(r.start, gapSize(r.start, next))
+
+		return 0
+	}
+
+	if r.end == next {
+		return 1
+	}
+
+	return 0
+}
+
+// unlink counts out the range linked before, it returns what link returned for it
+func (ri *RangeIndex) unlink(r hashRange, next HashDigest) int {
+	if !r.hasEnd {
+		ri.open.remove(r.start)
+
+		return 0
+	}
+
+	if r.end == next {
+		return 1
+	}
+
+	return 0
+}
+
 // isHashInRange determines whether a given hash falls within any of the stored hash ranges.
-func (ri *RangeIndex) isHashInRange(hash string) (inRange bool, exactRange string) {
+func (ri *RangeIndex) isHashInRange(hashString string) (inRange bool, exactRange string) {
+	hash, err := DecodeHash(hashString)
+
+	if err != nil {
+		return
+	}
+
+	r, inRange := ri.rangeOf(hash)
+
+	if inRange {
+		exactRange = encodeHash(r.start) + "=" + encodeHash(r.end)
+	}
+
+	return
+}
+
+// rangeOf returns the full range the hash falls within, inRange is false if there is none.
+func (ri *RangeIndex) rangeOf(hash HashDigest) (r hashRange, inRange bool) {
+	ri.mutex.RLock()
+	defer ri.mutex.RUnlock()
+
 	// first check edge case of hash being between last and first hash
-	lastHash, lastVal := ri.index.GetLastRange()
-	if lastVal != "" && lastVal < lastHash && (hash < lastVal || hash > lastHash) {
-		exactRange = lastHash + "=" + lastVal
+	if r, ok := ri.store.max(); ok {
+		if r.hasEnd && compareHash(r.end, r.start) < 0 && (compareHash(hash, r.end) <= 0 || compareHash(hash, r.start) > 0) {
+			return r, true
+		}
+	}
+
+	if r, ok := ri.store.lower(hash); ok {
+		if r.hasEnd && compareHash(hash, r.end) <= 0 {
+			return r, true
+		}
+	}
+
+	return
+}
+
+// Gaps returns up to limit uncovered parts of the hash space, largest first, all of them without a limit.
+// They come from the largest gaps kept in memory, when the gaps asked for may not be among them,
+// the ranges are scanned for them, and the kept gaps are found again.
+func (ri *RangeIndex) Gaps(limit int) (gaps []Gap) {
+	ri.mutex.Lock()
+	defer ri.mutex.Unlock()
+
+	if ri.store.Len() == 0 {
+		return []Gap{{Size: 1}}
+	}
+
+	largest, ok := ri.open.largest(limit)
 
-		return true, exactRange
+	if !ok && limit > 0 && limit <= ri.open.limit {
+		ri.rescanOpen()
+		largest, _ = ri.open.largest(limit)
+	} else if !ok {
+		largest = largestGaps(ri.scanOpen(), limit)
 	}
 
-	closestStart, closestEnd, hasClosest := ri.index.ClosestBefore(hash)
-	if hasClosest {
-		if hash <= closestEnd {
-			exactRange = closestStart + "=" + closestEnd
+	for _, gap := range largest {
+		end, _ := ri.store.higherCyclic(gap.start)
+		gaps = append(gaps, Gap{Start: encodeHash(gap.start), End: encodeHash(end.start), Size: gap.size})
+	}
 
-			return true, exactRange
+	return
+}
+
+// gapsVersion changes whenever any gap changes, Gaps returns the same gaps until then
+func (ri *RangeIndex) gapsVersion() uint64 {
+	ri.mutex.RLock()
+	defer ri.mutex.RUnlock()
+
+	return ri.open.version
+}
+
+// rescanOpen keeps the largest gaps again, from a pass over the ranges
+func (ri *RangeIndex) rescanOpen() {
+	version := ri.open.version
+	ri.open = newOpenGaps(ri.open.limit, ri.open.top.limit)
+
+	for gap := range ri.scanOpen() {
+		ri.open.add(gap.start, gap.size)
+	}
+
+	ri.open.version = version
+}
+
+// scanOpen returns gaps of the open hashes from a pass over the ranges, the last one reaches to the first hash
+func (ri *RangeIndex) scanOpen() iter.Seq[openGap] {
+	return func(yield func(openGap) bool) {
+		var first, prev hashRange
+		var cnt int
+
+		for r := range ri.store.all() {
+			if cnt == 0 {
+				first = r
+			} else if !prev.hasEnd && !yield(openGap{start: prev.start, size: gapSize(prev.start, r.start)}) {
+				return
+			}
+
+			prev = r
+			cnt++
+		}
+
+		if cnt > 0 && !prev.hasEnd {
+			yield(openGap{start: prev.start, size: gapSize(prev.start, first.start)})
 		}
 	}
+}
 
+// GapsSize returns the fraction of the hash space the gaps cover
+func GapsSize(gaps []Gap) (size float64) {
+	for _, gap := range gaps {
+		size += gap.Size
+	}
 	return
 }
 
-func (ri *RangeIndex) isFinished() (isFinished bool) {
-	if ri.cntEndWithoutStart.Load() == 0 {
-		if ri.index.allRangesComplete() {
-			isFinished = true
+// Overlaps returns known hashes lying inside a full range, which can't happen in a consistent chain.
+func (ri *RangeIndex) Overlaps() (overlaps []Overlap) {
+	ri.mutex.RLock()
+	defer ri.mutex.RUnlock()
+
+	var reach, wrap hashRange // range reaching furthest so far, range wrapping around the end of the hash space
+
+	for r := range ri.store.all() {
+		if reach.hasEnd && compareHash(r.start, reach.end) < 0 {
+			overlaps = append(overlaps, newOverlap(reach, r.start))
+		} else if wrap.hasEnd && compareHash(r.start, wrap.start) > 0 {
+			overlaps = append(overlaps, newOverlap(wrap, r.start))
+		}
+
+		if !r.hasEnd {
+			continue
+		}
+
+		if compareHash(r.end, r.start) < 0 {
+			wrap = r
+		} else if !reach.hasEnd || compareHash(r.end, reach.end) > 0 {
+			reach = r
+		}
+	}
+
+	if !wrap.hasEnd {
+		return
+	}
+
+	for r := range ri.store.all() {
+		if compareHash(r.start, wrap.end) >= 0 {
+			break
 		}
+
+		overlaps = append(overlaps, newOverlap(wrap, r.start))
 	}
 
 	return
 }
+
+func newOverlap(r hashRange, hash HashDigest) Overlap {
+	return Overlap{Start: encodeHash(r.start), End: encodeHash(r.end), Hash: encodeHash(hash)}
+}
+
+// gapFor returns start of the gap the hash falls into, ok is false if the hash is already covered.
+// An empty index is one gap, its start is the zero hash.
+func (ri *RangeIndex) gapFor(hash HashDigest) (gapStart HashDigest, ok bool) {
+	ri.mutex.RLock()
+	defer ri.mutex.RUnlock()
+
+	r, ok := ri.store.floor(hash)
+
+	if !ok {
+		r, ok = ri.store.max() // wraps around
+	}
+
+	if !ok {
+		return gapStart, true
+	}
+
+	return r.start, !r.hasEnd
+}
+
+// isFinished returns true when every known hash has a range ending at the next one, in constant time
+func (ri *RangeIndex) isFinished() bool {
+	ri.mutex.RLock()
+	defer ri.mutex.RUnlock()
+
+	return ri.open.cnt == 0 && ri.store.Len() > 0 && ri.cntLinked == ri.store.Len()
+}
+
+// Len returns count of known hashes
+func (ri *RangeIndex) Len() int {
+	ri.mutex.RLock()
+	defer ri.mutex.RUnlock()
+
+	return ri.store.Len()
+}
+
+// Close writes out and closes the disk index, an in-memory index has nothing to close
+func (ri *RangeIndex) Close() error {
+	ri.mutex.Lock()
+	defer ri.mutex.Unlock()
+
+	return ri.store.Close()
+}
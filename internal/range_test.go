@@ -0,0 +1,466 @@
+package nsec3walker
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// testChain returns cnt hashes of a chain in the order of the hash space
+func testChain(cnt int) (hashes []string) {
+	for i := 0; i < cnt; i++ {
+		hashes = append(hashes, encodeHash(testDigest(i, cnt)))
+	}
+
+	return
+}
+
+func testAdd(t *testing.T, ri *RangeIndex, start string, end string) (existsStart bool, existsEnd bool, setFull bool) {
+	t.Helper()
+
+	existsStart, existsEnd, setFull, err := ri.Add(start, end)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func TestRangeIndexAdd(t *testing.T) {
+	h := testChain(4)
+	ri := NewRangeIndex()
+
+	existsStart, existsEnd, setFull := testAdd(t, ri, h[0], h[1])
+
+	if existsStart || existsEnd || !setFull {
+		t.Fatalf("new range: %t %t %t", existsStart, existsEnd, setFull)
+	}
+
+	existsStart, existsEnd, setFull = testAdd(t, ri, h[0], h[1])
+
+	if !existsStart || !existsEnd || setFull {
+		t.Fatalf("the same range again: %t %t %t", existsStart, existsEnd, setFull)
+	}
+
+	// the end of the first range is known without its own end
+	existsStart, existsEnd, setFull = testAdd(t, ri, h[1], h[2])
+
+	if !existsStart || existsEnd || !setFull {
+		t.Fatalf("range from an open hash: %t %t %t", existsStart, existsEnd, setFull)
+	}
+
+	if ri.Len() != 3 {
+		t.Fatalf("%d hashes, want 3", ri.Len())
+	}
+
+	if _, _, _, err := ri.Add("not-a-hash", h[1]); !errors.Is(err, ErrInvalidHash) {
+		t.Fatalf("invalid hash got %v", err)
+	}
+}
+
+func TestRangeIndexIsFinished(t *testing.T) {
+	cnt := 1000
+	h := testChain(cnt)
+	ri := NewRangeIndex()
+	stride := benchmarkStride(cnt)
+
+	if ri.isFinished() {
+		t.Fatal("empty index is finished")
+	}
+
+	for i := 0; i < cnt; i++ {
+		if ri.isFinished() {
+			t.Fatalf("finished after %d of %d ranges", i, cnt)
+		}
+
+		pos := i * stride % cnt
+		testAdd(t, ri, h[pos], h[(pos+1)%cnt])
+	}
+
+	if !ri.isFinished() {
+		t.Fatalf("complete chain is not finished, %d gaps left", len(ri.Gaps(0)))
+	}
+}
+
+func TestRangeIndexIsFinishedSingleHash(t *testing.T) {
+	h := testChain(1)
+	ri := NewRangeIndex()
+	testAdd(t, ri, h[0], h[0])
+
+	if !ri.isFinished() || ri.Len() != 1 {
+		t.Fatalf("chain of one hash ending at itself is not finished, %d hashes", ri.Len())
+	}
+}
+
+func TestRangeIndexGaps(t *testing.T) {
+	h := testChain(8)
+	ri := NewRangeIndex()
+
+	if gaps := ri.Gaps(0); len(gaps) != 1 || gaps[0].Size != 1 {
+		t.Fatalf("empty index has gaps %v, want the whole hash space", gaps)
+	}
+
+	testAdd(t, ri, h[0], h[1])
+	testAdd(t, ri, h[2], h[3])
+
+	// the gap from the last hash wraps around to the first one
+	gaps := ri.Gaps(0)
+	want := []Gap{{Start: h[3], End: h[0], Size: 5.0 / 8}, {Start: h[1], End: h[2], Size: 1.0 / 8}}
+
+	if len(gaps) != len(want) {
+		t.Fatalf("%d gaps, want %d", len(gaps), len(want))
+	}
+
+	for i, gap := range gaps {
+		if gap.Start != want[i].Start || gap.End != want[i].End || math.Abs(gap.Size-want[i].Size) > 1e-9 {
+			t.Fatalf("gap %d is %v, want %v", i, gap, want[i])
+		}
+	}
+
+	if gaps = ri.Gaps(1); len(gaps) != 1 || gaps[0].Start != h[3] {
+		t.Fatalf("largest gap is %v, want the one from %s", gaps, h[3])
+	}
+
+	testAdd(t, ri, h[1], h[2])
+
+	if gaps = ri.Gaps(0); len(gaps) != 1 || gaps[0].Start != h[3] {
+		t.Fatalf("gaps %v after closing one, want the one from %s", gaps, h[3])
+	}
+}
+
+// testGaps returns sizes of all gaps of the index, computed from its ranges, largest first
+func testGaps(ri *RangeIndex) (sizes []float64) {
+	ranges := collectRanges(ri.store)
+
+	for i, r := range ranges {
+		if !r.hasEnd {
+			sizes = append(sizes, gapSize(r.start, ranges[(i+1)%len(ranges)].start))
+		}
+	}
+
+	slices.SortFunc(sizes, func(a, b float64) int { return cmp.Compare(b, a) })
+
+	return
+}
+
+// TestRangeIndexGapsKept keeps only a few gaps in memory, the largest ones must still be returned
+func TestRangeIndexGapsKept(t *testing.T) {
+	stores := map[string]func() *RangeIndex{
+		"memory": NewRangeIndex,
+		"disk": func() *RangeIndex {
+			ri, _, err := OpenRangeIndex(filepath.Join(t.TempDir(), "t"+SuffixIndex), testIndexKey, false)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return ri
+		},
+	}
+
+	for name, newIndex := range stores {
+		t.Run(name, func(t *testing.T) {
+			ri := newIndex()
+			defer ri.Close()
+
+			ri.open = newOpenGaps(16, 4)
+			ri.replaceOnChange = true
+			rnd := rand.New(rand.NewSource(1))
+			cnt := 5000
+			h := testChain(cnt)
+
+			for i := 0; i < 20_000; i++ {
+				start := rnd.Intn(cnt)
+				_, _, _, _ = ri.Add(h[start], h[(start+1+rnd.Intn(3))%cnt])
+
+				if i%500 != 0 {
+					continue
+				}
+
+				want := testGaps(ri)
+
+				if ri.open.cnt != len(want) || ri.open.kept.Len() > 16 {
+					t.Fatalf("%d open hashes with %d kept, want %d", ri.open.cnt, ri.open.kept.Len(), len(want))
+				}
+
+				for _, limit := range []int{0, 1, 10} {
+					gaps := ri.Gaps(limit)
+					wantLimit := want
+
+					if limit > 0 && len(want) > limit {
+						wantLimit = want[:limit]
+					}
+
+					if len(gaps) != len(wantLimit) {
+						t.Fatalf("%d gaps of limit %d, want %d", len(gaps), limit, len(wantLimit))
+					}
+
+					for j, gap := range gaps {
+						if gap.Size != wantLimit[j] {
+							t.Fatalf("gap %d of limit %d has size %f, want %f", j, limit, gap.Size, wantLimit[j])
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRangeIndexIsHashInRange(t *testing.T) {
+	cnt := 16
+	ri := NewRangeIndex()
+	testAdd(t, ri, encodeHash(testDigest(2, cnt)), encodeHash(testDigest(4, cnt)))
+	testAdd(t, ri, encodeHash(testDigest(14, cnt)), encodeHash(testDigest(1, cnt))) // wraps around
+
+	tests := []struct {
+		pos     int
+		inRange bool
+	}{
+		{0, true},  // wrapped range, after the end of the hash space
+		{1, true},  // end of the wrapped range
+		{2, false}, // start of a range is a known hash, not covered by it
+		{3, true},
+		{4, true},
+		{5, false},
+		{13, false},
+		{15, true}, // wrapped range, before the end of the hash space
+	}
+
+	for _, test := range tests {
+		hash := testDigest(test.pos, cnt)
+
+		if test.pos != 1 && test.pos != 2 && test.pos != 4 {
+			hash[HashSize-1]++ // between the known hashes
+		}
+
+		if inRange, where := ri.isHashInRange(encodeHash(hash)); inRange != test.inRange {
+			t.Errorf("hash at %d/%d in range: %t (%s), want %t", test.pos, cnt, inRange, where, test.inRange)
+		}
+	}
+}
+
+func TestRangeIndexZoneChange(t *testing.T) {
+	cnt := 8
+	h := testChain(cnt)
+
+	newChain := func(replaceOnChange bool) (ri *RangeIndex) {
+		ri = NewRangeIndex()
+		ri.replaceOnChange = replaceOnChange
+
+		for i := 0; i < cnt; i++ {
+			testAdd(t, ri, h[i], h[(i+1)%cnt])
+		}
+
+		return
+	}
+
+	// the zone dropped h[3] and h[4]
+	ri := newChain(false)
+	_, _, _, err := ri.Add(h[2], h[5])
+
+	var changeErr *RangeChangeError
+
+	if !errors.As(err, &changeErr) || changeErr.OldEnd != h[3] || changeErr.NewEnd != h[5] {
+		t.Fatalf("changed range got %v", err)
+	}
+
+	if ri.Len() != cnt || !ri.isFinished() {
+		t.Fatalf("refused change altered the index, %d hashes", ri.Len())
+	}
+
+	ri = newChain(true)
+	_, _, _, err = ri.Add(h[2], h[5])
+
+	if !errors.As(err, &changeErr) {
+		t.Fatalf("replaced range got %v", err)
+	}
+
+	if ri.Len() != cnt-2 || !ri.isFinished() {
+		t.Fatalf("replaced range left %d hashes, finished: %t", ri.Len(), ri.isFinished())
+	}
+
+	if inRange, _ := ri.isHashInRange(h[4]); !inRange {
+		t.Fatal("removed hash is not covered by the new range")
+	}
+}
+
+// TestRangeIndexZoneChangeWrapAround replaces the last range with one wrapping over the first hashes
+func TestRangeIndexZoneChangeWrapAround(t *testing.T) {
+	cnt := 8
+	h := testChain(cnt)
+	ri := NewRangeIndex()
+	ri.replaceOnChange = true
+
+	for i := 0; i < cnt; i++ {
+		testAdd(t, ri, h[i], h[(i+1)%cnt])
+	}
+
+	_, _, _, err := ri.Add(h[6], h[1])
+
+	if err == nil {
+		t.Fatal("changed range got no error")
+	}
+
+	if ri.Len() != cnt-2 || !ri.isFinished() {
+		t.Fatalf("replaced range left %d hashes, finished: %t", ri.Len(), ri.isFinished())
+	}
+
+	for _, hash := range []string{h[7], h[0]} {
+		if inRange, _ := ri.isHashInRange(hash); !inRange {
+			t.Fatalf("removed hash %s is not covered by the new range", hash)
+		}
+	}
+}
+
+func TestRangeIndexRemoveBetween(t *testing.T) {
+	cnt := 10
+	h := testChain(cnt)
+	ri := NewRangeIndex()
+
+	for i := 0; i < cnt; i++ {
+		testAdd(t, ri, h[i], h[(i+1)%cnt])
+	}
+
+	start, end := testDigest(8, cnt), testDigest(2, cnt)
+	ri.removeBetween(start, end)
+
+	// 9, 0 and 1 are strictly between, the range from 8 doesn't end at the next known hash now
+	if ri.Len() != cnt-3 || ri.isFinished() {
+		t.Fatalf("%d hashes left, finished: %t", ri.Len(), ri.isFinished())
+	}
+
+	for i, hash := range h {
+		_, exists := ri.store.get(testDigest(i, cnt))
+
+		if exists != (i >= 2 && i <= 8) {
+			t.Fatalf("hash %s exists: %t", hash, exists)
+		}
+	}
+
+	ri.removeBetween(end, end) // everything but the end itself
+
+	if ri.Len() != 1 {
+		t.Fatalf("%d hashes left, want 1", ri.Len())
+	}
+}
+
+func TestRangeIndexChangeAgreement(t *testing.T) {
+	cnt := 8
+	h := testChain(cnt)
+	ri := NewRangeIndex()
+	ri.replaceOnChange = true
+	ri.cntAgree = 2
+
+	for i := 0; i < cnt; i++ {
+		testAdd(t, ri, h[i], h[(i+1)%cnt])
+	}
+
+	for _, ns := range []string{"ns1", "ns1"} {
+		existsStart, existsEnd, setFull, err := ri.AddFrom(h[2], h[5], ns)
+
+		if err != nil || !existsStart || !existsEnd || setFull || ri.Len() != cnt {
+			t.Fatalf("change reported only by %s is not pending: %t %t %t %v", ns, existsStart, existsEnd, setFull, err)
+		}
+	}
+
+	if _, _, setFull, err := ri.AddFrom(h[2], h[5], "ns2"); err == nil || !setFull {
+		t.Fatal("change reported by the second server is not applied")
+	}
+
+	if ri.Len() != cnt-2 {
+		t.Fatalf("change reported by two servers left %d hashes", ri.Len())
+	}
+
+	// a lagging server returning the old range doesn't flip it back alone
+	_, _, _, _ = ri.AddFrom(h[2], h[3], "ns1")
+
+	if ri.Len() != cnt-2 {
+		t.Fatal("old range from one server was restored")
+	}
+}
+
+var benchmarkSizes = []int{1_000_000, 10_000_000, 100_000_000}
+
+func benchmarkName(cnt int) string {
+	return fmt.Sprintf("%dM", cnt/1_000_000)
+}
+
+// BenchmarkRangeIndexAdd builds a complete chain in a scattered order, 100M ranges need about 8 GB of memory.
+func BenchmarkRangeIndexAdd(b *testing.B) {
+	for _, cnt := range benchmarkSizes {
+		b.Run(benchmarkName(cnt), func(b *testing.B) {
+			if testing.Short() && cnt > 10_000_000 {
+				b.Skip("skipped in short mode")
+			}
+
+			hashes := testChain(cnt)
+			stride := benchmarkStride(cnt)
+			b.ResetTimer()
+
+			for n := 0; n < b.N; n++ {
+				ri := NewRangeIndex()
+
+				for i := 0; i < cnt; i++ {
+					pos := i * stride % cnt
+					_, _, _, _ = ri.Add(hashes[pos], hashes[(pos+1)%cnt])
+				}
+
+				if !ri.isFinished() {
+					b.Fatal("chain is not finished")
+				}
+			}
+
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*cnt), "ns/range")
+		})
+	}
+}
+
+func BenchmarkRangeIndexRangeOf(b *testing.B) {
+	for _, cnt := range benchmarkSizes {
+		b.Run(benchmarkName(cnt), func(b *testing.B) {
+			if testing.Short() && cnt > 10_000_000 {
+				b.Skip("skipped in short mode")
+			}
+
+			ri := NewRangeIndex()
+
+			for i := 0; i < cnt; i += 2 {
+				_, _, _, _ = ri.Add(encodeHash(testDigest(i, cnt)), encodeHash(testDigest(i+1, cnt)))
+			}
+
+			b.ResetTimer()
+
+			for n := 0; n < b.N; n++ {
+				ri.rangeOf(testDigest(n%1024, 1024))
+			}
+		})
+	}
+}
+
+func BenchmarkRangeIndexGaps(b *testing.B) {
+	for _, cnt := range benchmarkSizes {
+		b.Run(benchmarkName(cnt), func(b *testing.B) {
+			if testing.Short() && cnt > 10_000_000 {
+				b.Skip("skipped in short mode")
+			}
+
+			ri := NewRangeIndex()
+
+			for i := 0; i < cnt; i += 2 {
+				_, _, _, _ = ri.Add(encodeHash(testDigest(i, cnt)), encodeHash(testDigest(i+1, cnt)))
+			}
+
+			b.ResetTimer()
+
+			for n := 0; n < b.N; n++ {
+				ri.Gaps(1000)
+			}
+		})
+	}
+}
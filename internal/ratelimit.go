@@ -0,0 +1,96 @@
+package nsec3walker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	QpsInitial           = 20
+	QpsMin               = 1
+	QpsMax               = 200
+	RateBurst            = 1
+	RateDecreaseFactor   = 0.5
+	RateDecreaseCooldown = time.Second
+)
+
+// RateLimiter is a token bucket for a single NS server. The rate is controlled AIMD-style,
+// it grows slowly while the server answers fine and is cut in half when it starts failing.
+type RateLimiter struct {
+	mutex        sync.Mutex
+	rate         float64 // queries per second
+	rateMin      float64
+	rateMax      float64
+	tokens       float64
+	last         time.Time
+	lastDecrease time.Time
+}
+
+func NewRateLimiter(rate float64, rateMin float64, rateMax float64) (rl *RateLimiter) {
+	rl = &RateLimiter{
+		rate:    rate,
+		rateMin: rateMin,
+		rateMax: rateMax,
+		tokens:  RateBurst,
+		last:    time.Now(),
+	}
+
+	return
+}
+
+// Wait blocks until a query can be sent, returns false if the context was cancelled meanwhile.
+func (rl *RateLimiter) Wait(ctx context.Context) bool {
+	for {
+		rl.mutex.Lock()
+		rl.refill()
+
+		if rl.tokens >= 1 {
+			rl.tokens--
+			rl.mutex.Unlock()
+
+			return true
+		}
+
+		wait := time.Duration((1 - rl.tokens) / rl.rate * float64(time.Second))
+		rl.mutex.Unlock()
+
+		if !sleepCtx(ctx, wait) {
+			return false
+		}
+	}
+}
+
+// Success increases the rate by roughly one query per second, every second.
+func (rl *RateLimiter) Success() {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	rl.rate = min(rl.rateMax, rl.rate+1/rl.rate)
+}
+
+// Failure cuts the rate. Parallel queries tend to fail together, so it is done once per cooldown.
+func (rl *RateLimiter) Failure() {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	if time.Since(rl.lastDecrease) < RateDecreaseCooldown {
+		return
+	}
+
+	rl.rate = max(rl.rateMin, rl.rate*RateDecreaseFactor)
+	rl.lastDecrease = time.Now()
+}
+
+func (rl *RateLimiter) Rate() float64 {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	return rl.rate
+}
+
+func (rl *RateLimiter) refill() {
+	now := time.Now()
+	rl.tokens = min(RateBurst, rl.tokens+now.Sub(rl.last).Seconds()*rl.rate)
+	rl.last = now
+}
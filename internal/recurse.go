@@ -0,0 +1,153 @@
+package nsec3walker
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const RecurseDepth = 1
+
+// RunRecurse walks NSEC3 signed delegations which have cracked names in the CSV file. Every child zone
+// gets its own output next to the CSV, and is cracked the same way as the parent, so its own delegations
+// can be walked too, down to RecurseDepth.
+func (nw *NSec3Walker) RunRecurse(ctx context.Context) (err error) {
+	return nw.recurse(ctx, nw.config, 1, make(map[string]bool))
+}
+
+func (nw *NSec3Walker) recurse(ctx context.Context, config *Config, depth int, seen map[string]bool) (err error) {
+	delegations, err := readDelegations(config.FileCsv, config.Output)
+
+	if err != nil {
+		return
+	}
+
+	config.Output.Logf("Found %d delegations in %s, depth %d", len(delegations), config.FileCsv, depth)
+
+	for _, delegation := range delegations {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if seen[delegation] {
+			continue
+		}
+
+		seen[delegation] = true
+		child, ok := nw.walkChild(ctx, config, delegation)
+
+		if ok && depth < nw.config.RecurseDepth {
+			err = nw.recurse(ctx, child, depth+1, seen)
+		}
+
+		child.Output.Close()
+
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// walkChild walks the child zone and cracks its hashes, ok is false if there is nothing to recurse into.
+func (nw *NSec3Walker) walkChild(ctx context.Context, parent *Config, domain string) (child *Config, ok bool) {
+	child = nw.config.forZone(domain)
+
+	if !nw.isNsec3Zone(ctx, child) {
+		child.Output.Log("Not signed with NSEC3, skipping")
+
+		return
+	}
+
+	err := child.setZoneOutput(filepath.Dir(parent.FileCsv))
+
+	if err == nil {
+		err = NewNSec3Walker(child).RunWalk(ctx)
+	}
+
+	if err != nil {
+		child.Output.Log(err.Error())
+
+		return
+	}
+
+	child.Output.Flush()
+	child.FileCsv = child.filePathPrefix + SuffixCsv
+
+	if nw.config.Action == ActionCrack {
+		err = NewCracking(child, child.Output).Run()
+	} else {
+		err = NewNSec3Walker(child).RunCsvUpdate()
+	}
+
+	if err != nil {
+		child.Output.Log(err.Error())
+
+		return
+	}
+
+	return child, true
+}
+
+// isNsec3Zone checks if any authoritative NS server of the zone has NSEC3PARAM.
+// Found NS servers are kept, so the walk doesn't need to look them up again.
+func (nw *NSec3Walker) isNsec3Zone(ctx context.Context, config *Config) bool {
+	err := config.processAuthNsServers(false)
+
+	if err == nil {
+		err = config.resolveNameServers(ctx)
+	}
+
+	if err != nil {
+		config.Output.Log(err.Error())
+
+		return false
+	}
+
+	config.domainServerInput = strings.Join(config.DomainDnsServers, ",")
+
+	for _, ns := range config.NameServers {
+		if _, err = nw.client.getNsec3ParamResponse(config.Domain, ns.Address); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readDelegations returns cracked names from the CSV file which have NS records, except the zone apex.
+func readDelegations(filePath string, out *Output) (delegations []string, err error) {
+	csv, err := NewCsv(filePath, out)
+
+	if err != nil {
+		return
+	}
+
+	defer csv.FileInput.Resource.Close()
+
+	seen := make(map[string]bool)
+
+	err = csv.ForEach(func(csvItem CsvItem) error {
+		name := normalizeDomain(csvItem.Plaintext)
+		isApex := name == normalizeDomain(csvItem.Domain)
+
+		if name == "" || isApex || seen[name] || !slices.Contains(csvItem.Types, dns.TypeToString[dns.TypeNS]) {
+			return nil
+		}
+
+		seen[name] = true
+		delegations = append(delegations, name)
+
+		return nil
+	})
+
+	return
+}
+
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.Trim(domain, "."))
+}
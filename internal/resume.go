@@ -0,0 +1,157 @@
+package nsec3walker
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// resumeFromCsv loads ranges from a CSV file of a previous walk into the index,
+// so only hashes and ranges not seen before are written out.
+func (nw *NSec3Walker) resumeFromCsv(filePath string) (err error) {
+	if _, err = os.Stat(filePath); os.IsNotExist(err) {
+		nw.out.Log("Nothing to resume, " + filePath + " does not exist yet")
+
+		return nil
+	}
+
+	csv, err := NewCsv(filePath, nw.out)
+	if err != nil {
+		return
+	}
+
+	defer csv.FileInput.Resource.Close()
+
+	cntRanges := 0
+
+	err = csv.ForEach(func(csvItem CsvItem) (err error) {
+		err = nw.checkResumedItem(csvItem)
+		if err != nil {
+			return
+		}
+
+		startExists, endExists, _, errAdd := nw.ranges.Add(csvItem.Hash, csvItem.HashNext)
+		if errAdd != nil {
+			nw.out.LogVerbose(errAdd.Error())
+		}
+
+		nw.stats.gotHash(startExists, endExists)
+		cntRanges++
+
+		return
+	})
+
+	if err != nil {
+		return
+	}
+
+	nw.chain.cntHashes = nw.stats.hashes.Load()
+	nw.out.Logf("Resumed %d ranges with %d hashes from %s", cntRanges, nw.stats.hashes.Load(), filePath)
+
+	return
+}
+
+// resumeNsecFromCsv loads cleartext names of a previous NSEC walk, the walk continues from the last one
+// and names already in the CSV file are not written again.
+func (nw *NSec3Walker) resumeNsecFromCsv(filePath string) (written map[string]bool, last string, err error) {
+	written = make(map[string]bool)
+
+	if _, err = os.Stat(filePath); os.IsNotExist(err) {
+		nw.out.Log("Nothing to resume, " + filePath + " does not exist yet")
+
+		return written, "", nil
+	}
+
+	csv, err := NewCsv(filePath, nw.out)
+	if err != nil {
+		return
+	}
+
+	defer csv.FileInput.Resource.Close()
+
+	domain := strings.Trim(strings.ToLower(nw.nsec.domain), ".")
+
+	err = csv.ForEach(func(csvItem CsvItem) error {
+		if strings.Trim(strings.ToLower(csvItem.Domain), ".") != domain {
+			return fmt.Errorf("Can't resume, CSV file is for domain [%s]", csvItem.Domain)
+		}
+
+		if csvItem.Hash != "" {
+			return fmt.Errorf("Can't resume, CSV file has NSEC3 ranges, but the zone is signed with NSEC now")
+		}
+
+		last = strings.ToLower(dns.Fqdn(csvItem.Plaintext))
+		written[last] = true
+
+		return nil
+	})
+
+	if err == nil {
+		nw.out.Logf("Resumed %d names from %s", len(written), filePath)
+	}
+
+	return
+}
+
+// resumeFromIndex continues with ranges restored from --index-dir, the CSV file is not read again.
+func (nw *NSec3Walker) resumeFromIndex() {
+	cntHashes := int64(nw.ranges.Len())
+	nw.stats.hashes.Store(cntHashes)
+	nw.chain.cntHashes = cntHashes
+	nw.out.Logf("Resumed %d hashes from the index in %s", cntHashes, nw.config.IndexDir)
+}
+
+func (nw *NSec3Walker) checkResumedItem(csvItem CsvItem) (err error) {
+	domain := strings.Trim(strings.ToLower(csvItem.Domain), ".")
+
+	if domain != strings.Trim(strings.ToLower(nw.nsec.domain), ".") {
+		return fmt.Errorf("Can't resume, CSV file is for domain [%s]", csvItem.Domain)
+	}
+
+	if !strings.EqualFold(csvItem.Salt, nw.nsec.saltString) || csvItem.Iterations != int(nw.nsec.iterations) {
+		msg := "Can't resume, NSEC3 params changed from salt [%s] and [%d] iterations to [%s] and [%d]"
+
+		return fmt.Errorf(msg, csvItem.Salt, csvItem.Iterations, nw.nsec.saltString, nw.nsec.iterations)
+	}
+
+	return
+}
+
+func (nw *NSec3Walker) logGaps() {
+	gaps := nw.ranges.Gaps(0)
+	nw.out.Logf("Filling %d gaps covering %.6f%% of the hash space", len(gaps), GapsSize(gaps)*100)
+}
+
+// readCsvDomain returns the domain of the first NSEC3 range in the CSV file.
+func readCsvDomain(filePath string) (domain string, err error) {
+	file, err := os.Open(filePath)
+
+	if err != nil {
+		return
+	}
+
+	defer file.Close()
+
+	reader := newCsvReader(file)
+
+	for domain == "" {
+		record, errRead := reader.Read()
+
+		if errRead == io.EOF {
+			return "", fmt.Errorf("no NSEC3 ranges in %s", filePath)
+		}
+
+		if errRead != nil {
+			return "", errRead
+		}
+
+		if !isCsvHeader(record) && len(record) >= CntCsvFilePartsLegacy && record[0] != "" {
+			domain = normalizeDomain(record[2])
+		}
+	}
+
+	return
+}
@@ -0,0 +1,141 @@
+package nsec3walker
+
+import "fmt"
+
+// Sink receives everything the walk outputs, Output fans out to all of its sinks.
+type Sink interface {
+	Hash(hash HashEvent) error
+	Range(nsecRange RangeEvent) error
+	Name(name NameEvent) error
+	Change(change ZoneChange) error
+	Log(message string) error
+	Progress(progress ProgressEvent) error
+	Flush() error
+	Close() error
+}
+
+// OutputFiles is the sink of prefix.[log,csv,hash,changes] files.
+type OutputFiles struct {
+	ChangesFile *File
+	HashFile    *File
+	LogFile     *File
+	MapFile     *File
+}
+
+func NewFiles(fileAbs string) (files *OutputFiles, err error) {
+	files = &OutputFiles{}
+
+	files.HashFile, err = NewFile(fileAbs+SuffixHash, BuffSizeHash)
+
+	if err != nil {
+		return
+	}
+
+	files.MapFile, err = NewFile(fileAbs+SuffixCsv, BuffSizeCsv)
+
+	if err == nil {
+		err = writeCsvHeader(files.MapFile)
+	}
+
+	if err != nil {
+		_ = files.HashFile.Close()
+
+		return
+	}
+
+	files.LogFile, err = NewFile(fileAbs+SuffixLog, 0)
+
+	if err != nil {
+		_ = files.HashFile.Close()
+		_ = files.MapFile.Close()
+
+		return
+	}
+
+	files.ChangesFile, err = NewFile(fileAbs+SuffixChanges, 0)
+
+	if err != nil {
+		_ = files.HashFile.Close()
+		_ = files.MapFile.Close()
+		_ = files.LogFile.Close()
+	}
+
+	return
+}
+
+func (fi *OutputFiles) Hash(hash HashEvent) error {
+	return fi.HashFile.Write(hashToHashcat(hash) + "\n")
+}
+
+func (fi *OutputFiles) Range(nsecRange RangeEvent) error {
+	csvItem := CsvItem{
+		Hash:       nsecRange.Start,
+		HashNext:   nsecRange.End,
+		Domain:     nsecRange.Domain,
+		Salt:       nsecRange.Salt,
+		Iterations: nsecRange.Iterations,
+		Types:      nsecRange.Types,
+		Validation: nsecRange.Validation,
+	}
+
+	return fi.MapFile.Write(csvItem.toCsv() + "\n")
+}
+
+// Name writes a cleartext name from NSEC walk, into CSV without hashes.
+func (fi *OutputFiles) Name(name NameEvent) error {
+	csvItem := CsvItem{
+		Domain:    name.Domain,
+		Plaintext: name.Name,
+		Types:     name.Types,
+	}
+
+	return fi.MapFile.Write(csvItem.toCsv() + "\n")
+}
+
+func (fi *OutputFiles) Change(change ZoneChange) error {
+	return fi.ChangesFile.Write(change.toCsv() + "\n")
+}
+
+func (fi *OutputFiles) Log(message string) error {
+	return fi.LogFile.Write(message + "\n")
+}
+
+// Progress is logged already, nothing more to write
+func (fi *OutputFiles) Progress(_ ProgressEvent) error {
+	return nil
+}
+
+func (fi *OutputFiles) Flush() error {
+	for _, file := range []*File{fi.HashFile, fi.MapFile, fi.LogFile, fi.ChangesFile} {
+		if file != nil {
+			_ = file.Flush()
+		}
+	}
+
+	return nil
+}
+
+func (fi *OutputFiles) Close() error {
+	if fi.HashFile != nil {
+		_ = fi.HashFile.Close()
+	}
+
+	if fi.MapFile != nil {
+		_ = fi.MapFile.Close()
+	}
+
+	if fi.LogFile != nil {
+		_ = fi.LogFile.Close()
+	}
+
+	if fi.ChangesFile != nil {
+		_ = fi.ChangesFile.Close()
+	}
+
+	return nil
+}
+
+// hashToHashcat returns the hash in the format of Hashcat mode 8300
+func hashToHashcat(hash HashEvent) string {
+	return fmt.Sprintf("%s:.%s:%s:%d", hash.Hash, hash.Domain, hash.Salt, hash.Iterations)
+}
@@ -1,28 +1,46 @@
 package nsec3walker
 
 import (
+	"context"
 	"fmt"
+	"maps"
 	"math"
-	"os"
+	"slices"
+	"strings"
 	"sync/atomic"
 	"time"
 )
 
 type Stats struct {
 	out                  *Output
+	started              time.Time
 	queries              atomic.Int64
 	hashes               atomic.Int64
 	queriesWithoutResult atomic.Int64
 	secondsWithoutResult atomic.Int64
+	truncated            atomic.Int64
+	tcpFallbacks         atomic.Int64
+	changes              atomic.Int64
+	invalidSignatures    atomic.Int64
+	rateLimiters         map[string]*RateLimiter
+	onInterval           func() // called after counters are logged
 }
 
 func NewStats(out *Output) *Stats {
 	return &Stats{
-		out: out,
+		out:          out,
+		started:      time.Now(),
+		rateLimiters: make(map[string]*RateLimiter),
 	}
 }
 
-func (stats *Stats) logCounterChanges(interval time.Duration, quitAfterMin int) {
+// addRateLimiter registers limiter for reporting, must be called before logCounterChanges starts.
+func (stats *Stats) addRateLimiter(ns string, limiter *RateLimiter) {
+	stats.rateLimiters[ns] = limiter
+}
+
+// logCounterChanges periodically logs counters and calls stop once there were no new hashes for quitAfterMin.
+func (stats *Stats) logCounterChanges(ctx context.Context, stop func(error), interval time.Duration, quitAfterMin int) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
@@ -30,7 +48,12 @@ func (stats *Stats) logCounterChanges(interval time.Duration, quitAfterMin int)
 	var cntHashLast int64
 
 	for {
-		<-ticker.C
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
 		cntQuery := stats.queries.Load()
 		cntHash := stats.hashes.Load()
 		cntQ := atomic.LoadInt64(&cntQuery)
@@ -46,7 +69,11 @@ func (stats *Stats) logCounterChanges(interval time.Duration, quitAfterMin int)
 		msg := "In the last %v: Queries total/change %d/%d | Hashes total/change: %d/%d | Ratio total/change %d%%/%d%%"
 		msg += " | Without answer: %d , seconds %d"
 		msgLog := fmt.Sprintf(msg, interval, cntQ, deltaQ, cntH, deltaH, ratioTotal, ratioDelta, qWithoutResult, secWithoutResult)
-		stats.out.Log(msgLog)
+		stats.out.Log(msgLog + stats.truncatedMessage() + stats.changesMessage() + stats.ratesMessage())
+
+		if stats.onInterval != nil {
+			stats.onInterval()
+		}
 
 		cntQueryLast = cntQ
 		cntHashLast = cntH
@@ -54,11 +81,61 @@ func (stats *Stats) logCounterChanges(interval time.Duration, quitAfterMin int)
 
 		if stats.secondsWithoutResult.Load() >= int64(quitAfterMin*60) {
 			stats.out.Logf("No new hashes for %d seconds, quitting", secWithoutResult)
-			os.Exit(0) // successful run
+			stop(errNoNewHashes) // successful run
+
+			return
 		}
 	}
 }
 
+func (stats *Stats) truncatedMessage() (msg string) {
+	cntTruncated := stats.truncated.Load()
+
+	if cntTruncated == 0 {
+		return
+	}
+
+	return fmt.Sprintf(" | Truncated/TCP fallbacks: %d/%d", cntTruncated, stats.tcpFallbacks.Load())
+}
+
+func (stats *Stats) ratesMessage() (msg string) {
+	if len(stats.rateLimiters) == 0 {
+		return
+	}
+
+	var rates []string
+
+	for _, ns := range slices.Sorted(maps.Keys(stats.rateLimiters)) {
+		rates = append(rates, fmt.Sprintf("%s %.1f", ns, stats.rateLimiters[ns].Rate()))
+	}
+
+	return " | QPS: " + strings.Join(rates, ", ")
+}
+
+func (stats *Stats) logSummary() {
+	cntQ := stats.queries.Load()
+	cntH := stats.hashes.Load()
+	duration := time.Since(stats.started).Round(time.Second)
+
+	msg := "Summary after %v: Queries %d | Hashes %d | Ratio %d%%"
+	msg += stats.truncatedMessage() + stats.changesMessage()
+	stats.out.Logf(msg, duration, cntQ, cntH, stats.calculateRatio(cntH, cntQ))
+}
+
+func (stats *Stats) changesMessage() (msg string) {
+	cntChanges := stats.changes.Load()
+
+	if cntChanges > 0 {
+		msg = fmt.Sprintf(" | Zone changes: %d", cntChanges)
+	}
+
+	if cntInvalid := stats.invalidSignatures.Load(); cntInvalid > 0 {
+		msg += fmt.Sprintf(" | Invalid signatures: %d", cntInvalid)
+	}
+
+	return
+}
+
 func (stats *Stats) gotHash(startExists bool, endExists bool) {
 	add := 0
 
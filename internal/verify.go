@@ -0,0 +1,152 @@
+package nsec3walker
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrChainIncomplete = errors.New("NSEC3 chain is incomplete or inconsistent")
+
+// Verify rebuilds the chain from a CSV file and reports whether it is complete, offline.
+type Verify struct {
+	cnf      *Config
+	out      *Output
+	chains   map[string]*verifyChain // Nsec3Params.key => chain
+	keys     []string                // in order of appearance
+	cntNames int
+}
+
+// verifyChain is one set of NSEC3 params, there should be only one in a file
+type verifyChain struct {
+	nsec      Nsec3Params
+	ranges    *RangeIndex
+	cntRanges int
+	conflicts []*RangeChangeError
+}
+
+func NewVerify(config *Config) (verify *Verify) {
+	verify = &Verify{
+		cnf:    config,
+		out:    config.Output,
+		chains: make(map[string]*verifyChain),
+	}
+
+	return
+}
+
+func (v *Verify) Run() (err error) {
+	err = v.load()
+
+	if err != nil {
+		return
+	}
+
+	if v.cntNames > 0 {
+		v.out.Logf("%d cleartext names from a NSEC walk, they have no chain to verify", v.cntNames)
+	}
+
+	if len(v.keys) == 0 {
+		return
+	}
+
+	isValid := len(v.keys) == 1
+
+	if !isValid {
+		v.out.Logf("Mixed NSEC3 params, the file has %d chains:", len(v.keys))
+	}
+
+	for _, key := range v.keys {
+		isValid = v.report(v.chains[key]) && isValid
+	}
+
+	if !isValid {
+		err = ErrChainIncomplete
+	}
+
+	return
+}
+
+func (v *Verify) load() (err error) {
+	csv, err := NewCsv(v.cnf.FileCsv, v.out)
+
+	if err != nil {
+		return
+	}
+
+	defer csv.FileInput.Resource.Close()
+
+	err = csv.ForEach(v.add)
+
+	return
+}
+
+func (v *Verify) add(csvItem CsvItem) (err error) {
+	if csvItem.Hash == "" {
+		v.cntNames++
+
+		return
+	}
+
+	nsec, err := NewNsec3Params(normalizeDomain(csvItem.Domain), csvItem.Salt, csvItem.Iterations)
+
+	if err != nil {
+		return fmt.Errorf("invalid salt [%s]: %w", csvItem.Salt, err)
+	}
+
+	chain, exists := v.chains[nsec.key]
+
+	if !exists {
+		chain = &verifyChain{nsec: nsec, ranges: NewRangeIndex()}
+		v.chains[nsec.key] = chain
+		v.keys = append(v.keys, nsec.key)
+	}
+
+	_, _, isFull, err := chain.ranges.Add(csvItem.Hash, csvItem.HashNext)
+
+	var changeErr *RangeChangeError
+
+	if errors.As(err, &changeErr) {
+		chain.conflicts = append(chain.conflicts, changeErr)
+
+		return nil
+	}
+
+	if isFull {
+		chain.cntRanges++
+	}
+
+	return
+}
+
+// report logs the state of the chain, returns true if it is complete and consistent
+func (v *Verify) report(chain *verifyChain) (isValid bool) {
+	gaps := chain.ranges.Gaps(0)
+	overlaps := chain.ranges.Overlaps()
+	isComplete := chain.ranges.isFinished()
+	isValid = isComplete && len(chain.conflicts) == 0 && len(overlaps) == 0
+
+	state := "complete"
+
+	if !isComplete {
+		state = fmt.Sprintf("incomplete, %d gaps cover %.6f%% of the hash space", len(gaps), GapsSize(gaps)*100)
+	}
+
+	msg := "Chain [%s] salt [%s] iterations [%d]: %d ranges, %s"
+	v.out.Logf(msg, chain.nsec.domain, chain.nsec.saltString, chain.nsec.iterations, chain.cntRanges, state)
+
+	if !isComplete {
+		for _, gap := range gaps {
+			v.out.Logf("Gap %s -> %s, %.6f%%", gap.Start, gap.End, gap.Size*100)
+		}
+	}
+
+	for _, conflict := range chain.conflicts {
+		v.out.Logf("Inconsistent range %s -> %s, also listed with the end %s", conflict.Start, conflict.OldEnd, conflict.NewEnd)
+	}
+
+	for _, overlap := range overlaps {
+		v.out.Logf("Overlapping range %s -> %s covers the hash %s", overlap.Start, overlap.End, overlap.Hash)
+	}
+
+	return
+}
@@ -1,28 +1,49 @@
 package nsec3walker
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/miekg/dns"
 )
 
-const (
-	WaitMs         = 100
-	sizeChanDomain = 500
-	ErrorBlackLies = "black_lies"
-	ErrorWhiteLies = "white_lies"
+const sizeChanDomain = 500
+
+var (
+	errWalkFinished  = errors.New("walk finished")
+	errNoNewHashes   = errors.New("no new hashes")
+	ErrParamsChanged = errors.New("NSEC3 params changed")
+	ErrBadRcode      = errors.New("bad response code")
+	ErrNoNsec3       = errors.New("not supporting NSEC3")
+	ErrBlackLies     = errors.New("black lies")
+	ErrWhiteLies     = errors.New("white lies")
+	ErrInvalidHash   = errors.New("invalid NSEC3 hash")
+	ErrIndexFailed   = errors.New("range index failed")
 )
 
 type NSec3Walker struct {
-	config       *Config
-	stats        *Stats
-	ranges       *RangeIndex
-	out          *Output
-	nsec         Nsec3Params
-	cntNsWorkers int
+	config    *Config
+	stats     *Stats
+	ranges    *RangeIndex
+	client    *DnsClient
+	health    *HealthTracker
+	validator *Validator // nil if the signatures are not validated
+	out       *Output
+	nsec      Nsec3Params
+	wgWorkers sync.WaitGroup
+	cancel    context.CancelCauseFunc
+	stopCause error
+
+	queriesRolled int64 // queries of the chains walked before a rollover, stats are of the walked chain
+
+	chain       *Chain            // the primary chain, being walked
+	chains      map[string]*Chain // Nsec3Params.key => chain
+	chainOrder  []*Chain
+	chainsMutex sync.Mutex
 
 	chanDomain      chan *Domain
 	chanHashesFound chan Nsec3Record
@@ -30,9 +51,12 @@ type NSec3Walker struct {
 }
 
 type Nsec3Record struct {
-	Start string
-	End   string
-	Types []uint16
+	Start      string
+	End        string
+	Types      []uint16
+	Ns         string // NS server which returned the record
+	Validation string // ValidationValid if the RRSIG was verified, empty without validation
+	chain      *Chain
 }
 
 func NewNSec3Walker(config *Config) (nsecWalker *NSec3Walker) {
@@ -42,11 +66,16 @@ func NewNSec3Walker(config *Config) (nsecWalker *NSec3Walker) {
 		config:          config,
 		chanHashesFound: make(chan Nsec3Record, 1000),
 		ranges:          NewRangeIndex(),
+		client:          NewDnsClient(config.Transport, config.EdnsSize, stats),
+		health:          NewHealthTracker(config.Output),
 		out:             config.Output,
 		stats:           stats,
+		chains:          make(map[string]*Chain),
 	}
 
 	nsecWalker.nsec.domain = config.Domain
+	nsecWalker.stats.onInterval = nsecWalker.emitProgress
+	nsecWalker.ranges.replaceOnChange = !config.QuitOnChange
 
 	return
 }
@@ -63,7 +92,7 @@ func (nw *NSec3Walker) RunDebug() (err error) {
 	nw.out.Log(fmt.Sprintf("NS servers to walk: %v", nw.config.DomainDnsServers))
 
 	for _, ns := range nw.config.DomainDnsServers {
-		r, err := getNsResponse(domain, ns)
+		r, err := nw.client.getNsResponse(domain, ns)
 
 		fmt.Printf("[%s] @ [%s]\n===Err===\n%v\n\n===Response===\n%s\n\n\n", domain, ns, err, r)
 
@@ -91,40 +120,138 @@ func (nw *NSec3Walker) RunCrack() (err error) {
 	return
 }
 
-func (nw *NSec3Walker) RunWalk() (err error) {
+func (nw *NSec3Walker) RunWalk(ctx context.Context) (err error) {
 	err = nw.config.processAuthNsServers(false)
 
+	if err == nil {
+		err = nw.config.resolveNameServers(ctx)
+	}
+
 	if err != nil {
 		return
 	}
 
 	nw.out.Log("Starting NSEC3 walker for domain [" + nw.nsec.domain + "]")
-	nw.out.Log(fmt.Sprintf("NS servers to walk: %v", nw.config.DomainDnsServers))
+	nw.out.Log(fmt.Sprintf("NS servers to walk: %v", nw.config.NameServers))
 
+	nameServers := nw.config.NameServers
+	defer nw.closeChainIndexes()
 	err = nw.initNsec3Values()
 
+	if errors.Is(err, ErrNoNsec3) {
+		nw.config.NameServers = nameServers
+
+		if nw.isNsecZone() {
+			return nw.runNsecWalk(ctx)
+		}
+	}
+
 	if err != nil {
 		return
 	}
 
-	nw.chanDomain = make(chan *Domain, sizeChanDomain)
-	dg, err := NewDomainGenerator(nw.nsec.domain, nw.nsec.saltString, nw.nsec.iterations, nw.ranges, nw.out)
+	if nw.config.Validate {
+		err = nw.initValidator()
+
+		if err != nil {
+			return
+		}
+	}
+
+	if nw.config.Resume {
+		if nw.ranges.Len() > 0 {
+			nw.resumeFromIndex() // restored from --index-dir
+		} else {
+			err = nw.resumeFromCsv(nw.config.filePathPrefix + SuffixCsv)
+		}
+
+		if err != nil {
+			return
+		}
+
+		if nw.ranges.isFinished() {
+			nw.out.Log(fmt.Sprintf("Walk was already finished with %d hashes", nw.stats.hashes.Load()))
+
+			return
+		}
+
+		if nw.config.FillGaps != "" {
+			nw.logGaps()
+		}
+	}
+
+	defer nw.closeChainOutputs()
+	defer nw.logChains()
+
+	for {
+		err = nw.walkChain(ctx)
+
+		var paramsErr *ParamsChangeError
+
+		if !errors.As(err, &paramsErr) {
+			return
+		}
+
+		err = nw.rollover(paramsErr)
+
+		if err != nil {
+			return
+		}
+
+		if nw.chain.isFinished {
+			// collected completely before it was rolled over to
+			nw.stopCause = errWalkFinished
+			nw.out.Log(fmt.Sprintf("Finished with %d hashes", nw.chain.cntHashes))
+
+			return
+		}
+	}
+}
+
+// walkChain walks the NSEC3 chain of the current params, until it is complete or the walk stops.
+func (nw *NSec3Walker) walkChain(ctx context.Context) (err error) {
+	sizeChan := sizeChanDomain
+
+	if nw.config.Strategy == StrategyGaps {
+		sizeChan = 0 // domains are handed to idle workers directly, so the gaps in flight stay spread
+	}
+
+	nw.chanDomain = make(chan *Domain, sizeChan)
+	n3p := nw.nsec
+	dg, err := NewDomainGenerator(n3p.domain, n3p.saltString, n3p.iterations, nw.ranges, nw.out, nw.config.Strategy)
 	if err != nil {
 		return
 	}
 
-	dg.Run(nw.chanDomain)
+	ctx, nw.cancel = context.WithCancelCause(ctx)
+	defer nw.cancel(nil)
+
+	dg.Run(ctx, nw.chanDomain)
+
+	for _, ns := range nw.config.NameServers {
+		limiter := NewRateLimiter(nw.config.QpsInitial, nw.config.QpsMin, nw.config.QpsMax)
+		nw.stats.addRateLimiter(ns.String(), limiter)
 
-	for _, ns := range nw.config.DomainDnsServers {
 		for i := 0; i < nw.config.cntThreadsPerNs; i++ {
-			nw.cntNsWorkers++
-			go nw.workerForAuthNs(ns)
+			nw.wgWorkers.Add(1)
+			go nw.workerForAuthNs(ctx, ns, limiter)
 		}
 	}
 
-	go nw.stats.logCounterChanges(time.Second*time.Duration(nw.config.LogCounterIntervalSec), nw.config.QuitAfterMin)
+	go func() {
+		// Hashes are processed until the last worker is gone, so nothing in flight is lost
+		nw.wgWorkers.Wait()
+		close(nw.chanHashesFound)
+	}()
+
+	interval := time.Second * time.Duration(nw.config.LogCounterIntervalSec)
+	go nw.stats.logCounterChanges(ctx, nw.cancel, interval, nw.config.QuitAfterMin)
 
-	err = nw.processHashes()
+	nw.processHashes()
+	err = nw.stopReason(ctx)
+	nw.stats.logSummary()
+	nw.health.logScorecard()
+	nw.out.Flush()
 
 	return
 }
@@ -147,6 +274,37 @@ func (nw *NSec3Walker) RunCsvUpdate() (err error) {
 	return
 }
 
+func (nw *NSec3Walker) RunCsvMigrate() (err error) {
+	csv, err := NewCsv(nw.config.FileCsv, nw.out)
+
+	if err != nil {
+		return
+	}
+
+	migrated, err := csv.Migrate()
+
+	if err == nil && !migrated {
+		_ = csv.FileInput.Resource.Close()
+		nw.out.Log("CSV file is in the current format already.")
+	} else if err == nil {
+		nw.out.Log("CSV file was migrated to the current format.")
+	}
+
+	return
+}
+
+func (nw *NSec3Walker) RunCsvMerge() (err error) {
+	return NewMerge(nw.config).Run()
+}
+
+func (nw *NSec3Walker) RunCsvVerify() (err error) {
+	return NewVerify(nw.config).Run()
+}
+
+func (nw *NSec3Walker) RunCsvDiff() (err error) {
+	return NewDiff(nw.config, nw.config.diffFiles[0], nw.config.diffFiles[1]).Run()
+}
+
 func (nw *NSec3Walker) RunDump() (err error) {
 	dump, err := NewDump(nw.config)
 
@@ -157,153 +315,273 @@ func (nw *NSec3Walker) RunDump() (err error) {
 	return
 }
 
-func (nw *NSec3Walker) processHashes() (err error) {
-	var startExists, endExists, isFull bool
+func (nw *NSec3Walker) processHashes() {
+	var startExists, endExists, isFull, isFinished bool
+	var err error
 
 	for hash := range nw.chanHashesFound {
-		startExists, endExists, isFull, err = nw.ranges.Add(hash.Start, hash.End)
+		if errOut := nw.out.Err(); errOut != nil {
+			nw.cancel(errOut) // the rest is drained, the output would be incomplete
+
+			continue
+		}
+
+		if hash.chain != nw.chain {
+			nw.addToChain(hash)
+
+			continue
+		}
+
+		startExists, endExists, isFull, err = nw.ranges.AddFrom(hash.Start, hash.End, hash.Ns)
 
 		if err != nil {
-			if nw.config.QuitOnChange {
-				return // The error message will be printed by the caller
+			if nw.config.QuitOnChange || errors.Is(err, ErrIndexFailed) {
+				nw.cancel(err) // The error message will be printed by the caller
+
+				continue
 			}
 
-			// If the zone changes, and we don't quit, we can't determine if the chain is complete,
-			// so will need to rely on the timeout
-			nw.out.Log(err.Error())
+			// The index already keeps the newest range, so the chain can still be completed
+			nw.recordChange(err, hash.Ns)
 		}
 
 		nw.stats.gotHash(startExists, endExists)
 
 		if !startExists {
+			nw.chain.cntHashes++
 			nw.out.Hash(hash.Start, nw.nsec)
+			nw.emitHash(hash.Start, nw.nsec)
 		}
 
 		if !endExists {
+			nw.chain.cntHashes++
 			nw.out.Hash(hash.End, nw.nsec)
+			nw.emitHash(hash.End, nw.nsec)
 		}
 
 		if isFull {
 			nw.out.Csv(hash, nw.nsec)
+			nw.emitRange(hash, nw.nsec)
 		}
 
-		if nw.ranges.isFinished() {
-			nw.out.Log(fmt.Sprintf("Finished with %d hashes", nw.stats.hashes.Load()))
-
-			return
+		if !isFinished && nw.ranges.isFinished() {
+			isFinished = true
+			nw.chain.isFinished = true
+			nw.cancel(errWalkFinished)
 		}
 	}
+}
+
+func (nw *NSec3Walker) recordChange(err error, ns string) {
+	var changeErr *RangeChangeError
+
+	nw.emitError(err)
+
+	if !errors.As(err, &changeErr) {
+		nw.out.Log(err.Error() + " | from " + ns)
+
+		return
+	}
+
+	nw.out.Change(NewZoneChange(changeErr, ns))
+	nw.stats.changes.Add(1)
+	nw.health.conflict(ns)
+}
+
+// stopReason logs why the walk stopped and returns an error if it wasn't a clean stop.
+func (nw *NSec3Walker) stopReason(ctx context.Context) (err error) {
+	cause := context.Cause(ctx)
+	nw.stopCause = cause
+
+	switch {
+	case cause == nil:
+		nw.out.Log("There are no more NS to walk trough")
+	case errors.Is(cause, errWalkFinished):
+		nw.out.Log(fmt.Sprintf("Finished with %d hashes", nw.stats.hashes.Load()))
+	case errors.Is(cause, errNoNewHashes):
+		// already logged by stats
+	case errors.Is(cause, context.Canceled):
+		nw.out.Log("Walk interrupted, stopping")
+	default:
+		err = cause
+	}
 
 	return
 }
 
+// initNsec3Values gets NSEC3PARAM of every NS server. During rollovers they can differ, every params get
+// their own chain and the one published by the most servers is walked.
 func (nw *NSec3Walker) initNsec3Values() (err error) {
-	var hasNsec3Param bool
-	var domainDnsServers []string
+	var nameServers []NameServer
+	var keys []string
 
-	for _, ns := range nw.config.DomainDnsServers {
-		nsec3param, err := getNsec3ParamResponse(nw.nsec.domain, ns)
+	params := make(map[string]*dns.NSEC3PARAM)
+	servers := make(map[string][]string)
+
+	for _, ns := range nw.config.NameServers {
+		nsec3param, err := nw.client.getNsec3ParamResponse(nw.nsec.domain, ns.Address)
 
 		if err != nil {
-			nw.out.Log("[" + ns + "] removed - " + err.Error())
+			nw.out.Log("[" + ns.String() + "] removed - " + err.Error())
 
 			continue
 		}
 
-		err = nw.setNsec3Values(nsec3param.Salt, nsec3param.Iterations)
 		nsec3paramMsg := "NSEC3PARAM [%s] salt [%s] and [%d] iterations"
 		nw.out.Log(fmt.Sprintf(nsec3paramMsg, ns, nsec3param.Salt, nsec3param.Iterations))
-		domainDnsServers = append(domainDnsServers, ns)
-		hasNsec3Param = true
+		nameServers = append(nameServers, ns)
+		key := paramsKey(nw.nsec.domain, nsec3param.Salt, nsec3param.Iterations)
 
-		if err != nil {
-			return err
+		if _, exists := params[key]; !exists {
+			keys = append(keys, key)
+			params[key] = nsec3param
+		}
+
+		servers[key] = append(servers[key], ns.String())
+	}
+
+	nw.config.NameServers = nameServers
+
+	if len(keys) == 0 {
+		return fmt.Errorf("Domain [%s] is %w", nw.nsec.domain, ErrNoNsec3)
+	}
+
+	primary := keys[0]
+
+	for _, key := range keys {
+		if len(servers[key]) > len(servers[primary]) {
+			primary = key
 		}
 	}
 
-	nw.config.DomainDnsServers = domainDnsServers
+	err = nw.setPrimaryChain(params[primary].Salt, params[primary].Iterations)
 
-	if !hasNsec3Param {
-		err = fmt.Errorf("Domain [%s] is not supporting NSEC3", nw.nsec.domain)
+	for _, key := range keys {
+		for _, ns := range servers[key] {
+			if err == nil {
+				_, _, err = nw.chainFor(params[key].Salt, params[key].Iterations, ns)
+			}
+		}
 	}
 
 	return
 }
 
-func (nw *NSec3Walker) extractNSEC3Hashes(domain string, authNsServer string) (err error) {
-	r, err := getNsResponse(domain, authNsServer)
+func (nw *NSec3Walker) extractNSEC3Hashes(domain string, ns NameServer) (err error) {
+	r, err := nw.client.getNsResponse(domain, ns.Address)
 
 	if err != nil {
 		return
 	}
 
+	if r.Rcode == dns.RcodeRefused || r.Rcode == dns.RcodeServerFailure {
+		return fmt.Errorf("%w %s", ErrBadRcode, dns.RcodeToString[r.Rcode])
+	}
+
+	var errRollover error
+
 	for _, rr := range r.Ns {
 		if nsec, ok := rr.(*dns.NSEC); ok {
 			if strings.HasPrefix(nsec.NextDomain, "\\000") {
-				return errors.New(ErrorBlackLies)
+				return ErrBlackLies
 			}
 		}
 
 		if nsec3, ok := rr.(*dns.NSEC3); ok {
-			err = nw.setNsec3Values(nsec3.Salt, nsec3.Iterations)
+			validation := ""
+
+			if nw.validator != nil {
+				if errSig := nw.validator.verifyNsec3(nsec3, r.Ns); errSig != nil {
+					nw.stats.invalidSignatures.Add(1)
+					nw.logVerbose(errSig.Error() + " | from " + ns.String())
+
+					continue
+				}
+
+				validation = ValidationValid
+			}
 
-			if err != nil {
-				// salt or iterations changed, we need to start over
-				nw.out.Fatal(err)
+			chain, isCheckDue, errChain := nw.chainFor(nsec3.Salt, nsec3.Iterations, ns.String())
+
+			if errChain != nil {
+				return errChain
+			}
+
+			if isCheckDue && nw.isRollover(chain, ns) {
+				// the server publishes the new chain now, the walk continues with it once this round stops
+				errRollover = &ParamsChangeError{Old: nw.nsec, Salt: nsec3.Salt, Iterations: nsec3.Iterations}
 			}
 
 			hashStart := strings.ToLower(strings.Split(nsec3.Header().Name, ".")[0])
 			hashEnd := strings.ToLower(nsec3.NextDomain)
 
+			if !isHash(hashStart) || !isHash(hashEnd) {
+				return fmt.Errorf("%w [%s] -> [%s]", ErrInvalidHash, hashStart, hashEnd)
+			}
+
 			if hashStart[:len(hashStart)-1] == hashEnd[:len(hashStart)-1] {
-				return errors.New(ErrorWhiteLies)
+				return ErrWhiteLies
 			}
 
-			nw.chanHashesFound <- Nsec3Record{hashStart, hashEnd, nsec3.TypeBitMap}
+			nw.chanHashesFound <- Nsec3Record{hashStart, hashEnd, nsec3.TypeBitMap, ns.String(), validation, chain}
 		}
 	}
 
+	if errRollover != nil {
+		return errRollover
+	}
+
 	return
 }
 
-func (nw *NSec3Walker) setNsec3Values(salt string, iterations uint16) (err error) {
-	if nw.nsec.saltString == salt && nw.nsec.iterations == iterations {
-		return
-	}
+func (nw *NSec3Walker) workerForAuthNs(ctx context.Context, ns NameServer, limiter *RateLimiter) {
+	defer nw.wgWorkers.Done()
 
-	if nw.nsec.saltString != "" && nw.nsec.saltString != salt {
-		return fmt.Errorf("NSEC3 salt changed from %s to %s", nw.nsec.saltString, salt)
-	}
+	health := nw.health.get(ns.String())
 
-	if nw.nsec.iterations != 0 && nw.nsec.iterations != iterations {
-		return fmt.Errorf("NSEC3 iterations changed from %d to %d", nw.nsec.iterations, iterations)
-	}
+	for {
+		if !health.waitAvailable(ctx) {
+			break
+		}
 
-	nw.nsec.saltString = salt
-	nw.nsec.iterations = iterations
+		domain, ok := nw.nextDomain(ctx)
 
-	return
-}
+		if !ok {
+			health.cancelProbe()
+			break
+		}
 
-func (nw *NSec3Walker) workerForAuthNs(ns string) {
-	for domain := range nw.chanDomain {
 		if nw.isDomainInRange(domain) {
+			// no query is sent, another worker can probe the server
+			health.cancelProbe()
 			continue
 		}
 
-		time.Sleep(time.Millisecond * WaitMs)
+		if !limiter.Wait(ctx) {
+			health.cancelProbe()
+			break
+		}
 
+		timeStart := time.Now()
 		err := nw.extractNSEC3Hashes(domain.Domain, ns)
 		nw.stats.didQuery()
-
-		if err != nil {
-			if errNoConnection(err) {
-				nw.logVerbose(fmt.Sprintf("DNS server %s don't wanna talk with us, let's wait a while", ns))
-				time.Sleep(time.Second * 3)
-			} else if err.Error() == ErrorBlackLies {
+		health.record(err, time.Since(timeStart))
+
+		if err == nil {
+			limiter.Success()
+		} else {
+			nw.emitError(fmt.Errorf("[%s] %w", ns, err))
+
+			if errNoConnection(err) || errors.Is(err, ErrBadRcode) {
+				limiter.Failure()
+				nw.logVerbose(fmt.Sprintf("DNS server %s don't wanna talk with us (%v), slowing down", ns, err))
+			} else if errors.Is(err, ErrParamsChanged) {
+				nw.cancel(err)
+				break
+			} else if errors.Is(err, ErrBlackLies) {
 				nw.out.Log(fmt.Sprintf("Black lies from [%s]", ns))
 				break
-			} else if err.Error() == ErrorWhiteLies {
+			} else if errors.Is(err, ErrWhiteLies) {
 				nw.out.Log(fmt.Sprintf("White lies from [%s]", ns))
 				break
 			} else {
@@ -312,12 +590,28 @@ func (nw *NSec3Walker) workerForAuthNs(ns string) {
 		}
 	}
 
-	nw.cntNsWorkers--
 	nw.out.Logf("Closing worker for [%s]", ns)
+}
 
-	if nw.cntNsWorkers == 0 {
-		close(nw.chanHashesFound)
-		nw.out.Log("There are no more NS to walk trough")
+func (nw *NSec3Walker) nextDomain(ctx context.Context) (domain *Domain, ok bool) {
+	select {
+	case <-ctx.Done():
+		return nil, false
+	case domain = <-nw.chanDomain:
+		return domain, true
+	}
+}
+
+// sleepCtx returns false if the context was cancelled before the duration passed.
+func sleepCtx(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
 	}
 }
 
@@ -328,10 +622,10 @@ func (nw *NSec3Walker) logVerbose(text string) {
 }
 
 func (nw *NSec3Walker) isDomainInRange(domain *Domain) (inRange bool) {
-	inRange, where := nw.ranges.isHashInRange(domain.Hash)
+	r, inRange := nw.ranges.rangeOf(domain.digest)
 
-	if inRange {
-		nw.logVerbose(fmt.Sprintf("Domain in range [%s] <= %s (%s)", where, domain.Hash, domain.Domain))
+	if inRange && nw.config.Verbose {
+		nw.logVerbose(fmt.Sprintf("Domain in range [%s=%s] <= %s (%s)", encodeHash(r.start), encodeHash(r.end), domain.Hash, domain.Domain))
 	}
 
 	return
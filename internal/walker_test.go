@@ -0,0 +1,106 @@
+package nsec3walker
+
+import (
+	"context"
+	"testing"
+)
+
+// testSink records what the walk outputs
+type testSink struct {
+	hashes  []HashEvent
+	ranges  []RangeEvent
+	changes []ZoneChange
+}
+
+func (s *testSink) Hash(hash HashEvent) error {
+	s.hashes = append(s.hashes, hash)
+
+	return nil
+}
+
+func (s *testSink) Range(nsecRange RangeEvent) error {
+	s.ranges = append(s.ranges, nsecRange)
+
+	return nil
+}
+
+func (s *testSink) Change(change ZoneChange) error {
+	s.changes = append(s.changes, change)
+
+	return nil
+}
+
+func (s *testSink) Name(_ NameEvent) error         { return nil }
+func (s *testSink) Log(_ string) error             { return nil }
+func (s *testSink) Progress(_ ProgressEvent) error { return nil }
+func (s *testSink) Flush() error                   { return nil }
+func (s *testSink) Close() error                   { return nil }
+
+// testWalker returns a walker of a chain served by two NS servers, with its output going into the sink
+func testWalker(t *testing.T) (nw *NSec3Walker, sink *testSink) {
+	t.Helper()
+
+	config := NewWalkConfig("example.com")
+	config.NameServers = []NameServer{{Name: "ns1"}, {Name: "ns2"}}
+	config.Output.SetLogger(func(string) {})
+	config.Output.SetSilent(true)
+	sink = &testSink{}
+	config.Output.AddSink(sink)
+
+	nw = NewNSec3Walker(config)
+
+	if err := nw.setPrimaryChain("aabb", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+// testProcess runs the records through processHashes as if NS servers returned them
+func testProcess(nw *NSec3Walker, records ...Nsec3Record) {
+	_, nw.cancel = context.WithCancelCause(context.Background())
+	nw.chanHashesFound = make(chan Nsec3Record, len(records))
+
+	for _, record := range records {
+		record.chain = nw.chain
+		nw.chanHashesFound <- record
+	}
+
+	close(nw.chanHashesFound)
+	nw.processHashes()
+}
+
+// TestProcessHashesPendingChange checks a change reported by one NS server only writes nothing
+func TestProcessHashesPendingChange(t *testing.T) {
+	h := testChain(8)
+	nw, sink := testWalker(t)
+
+	testProcess(nw,
+		Nsec3Record{Start: h[2], End: h[3], Ns: "ns1"},
+		Nsec3Record{Start: h[3], End: h[4], Ns: "ns1"},
+		Nsec3Record{Start: h[4], End: h[5], Ns: "ns1"},
+	)
+
+	cntHashes, cntRanges := len(sink.hashes), len(sink.ranges)
+
+	testProcess(nw,
+		Nsec3Record{Start: h[2], End: h[6], Ns: "ns2"},
+		Nsec3Record{Start: h[2], End: h[6], Ns: "ns2"},
+	)
+
+	if len(sink.hashes) != cntHashes || len(sink.ranges) != cntRanges || len(sink.changes) != 0 {
+		t.Fatalf("pending change wrote %d hashes, %d ranges and %d changes",
+			len(sink.hashes)-cntHashes, len(sink.ranges)-cntRanges, len(sink.changes))
+	}
+
+	if nw.stats.changes.Load() != 0 || nw.stats.hashes.Load() != int64(cntHashes) {
+		t.Fatalf("pending change is counted, %d changes and %d hashes", nw.stats.changes.Load(), nw.stats.hashes.Load())
+	}
+
+	testProcess(nw, Nsec3Record{Start: h[2], End: h[6], Ns: "ns1"})
+
+	if len(sink.changes) != 1 || len(sink.hashes) != cntHashes+1 || len(sink.ranges) != cntRanges+1 {
+		t.Fatalf("agreed change wrote %d hashes, %d ranges and %d changes",
+			len(sink.hashes)-cntHashes, len(sink.ranges)-cntRanges, len(sink.changes))
+	}
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/unsecured-company/nsec3walker/internal"
+	"github.com/unsecured-company/nsec3walker/walker"
 )
 
 const Version = "2.0.6dev-250912"
@@ -14,6 +18,15 @@ func main() {
 	_, _ = fmt.Fprintln(os.Stderr, "nsec3walker "+Version+" | https://unsecured.company")
 
 	var err error
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		// first signal stops gracefully, restore the default handling so a second one kills the process
+		<-ctx.Done()
+		stop()
+	}()
+
 	config := initConfig()
 	nw := nsec3walker.NewNSec3Walker(config)
 	defer config.Output.Close()
@@ -22,19 +35,39 @@ func main() {
 	case nsec3walker.ActionHelp:
 		os.Exit(0)
 	case nsec3walker.ActionWalk:
-		err = nw.RunWalk()
+		err = runWalk(ctx, config)
 	case nsec3walker.ActionCrack:
 		err = nw.RunCrack()
+	case nsec3walker.ActionBenchmarkIndex:
+		err = nw.RunIndexBenchmark()
+	case nsec3walker.ActionMonitor:
+		err = nw.RunMonitor(ctx)
 	case nsec3walker.ActionDebug:
 		err = nw.RunDebug()
 	case nsec3walker.ActionUpdateCsv:
 		err = nw.RunCsvUpdate()
+	case nsec3walker.ActionMigrateCsv:
+		err = nw.RunCsvMigrate()
+	case nsec3walker.ActionMergeCsv:
+		err = nw.RunCsvMerge()
+	case nsec3walker.ActionVerifyCsv:
+		err = nw.RunCsvVerify()
+	case nsec3walker.ActionDiffCsv:
+		err = nw.RunCsvDiff()
 	case nsec3walker.ActionDumpDomains:
 		err = nw.RunDump()
 	case nsec3walker.ActionDumpWordlist:
 		err = nw.RunDump()
 	}
 
+	if err == nil && config.Recurse {
+		err = nw.RunRecurse(ctx)
+	}
+
+	if err == nil {
+		err = config.Output.Err()
+	}
+
 	if err != nil {
 		config.Output.Fatal(err)
 	}
@@ -49,3 +82,56 @@ func initConfig() (config *nsec3walker.Config) {
 
 	return
 }
+
+// runWalk walks through the public walker package, the same way programs embedding it do.
+func runWalk(ctx context.Context, config *nsec3walker.Config) (err error) {
+	w, err := walker.New(config.Domain, walkOptions(config)...)
+
+	if err != nil {
+		return
+	}
+
+	defer w.Close()
+
+	return w.Run(ctx)
+}
+
+// walkOptions maps flags of the walk command to walker options.
+func walkOptions(config *nsec3walker.Config) (options []walker.Option) {
+	options = []walker.Option{
+		walker.WithStdout(),
+		walker.WithResolvers(config.GetResolvers()...),
+		walker.WithRateLimit(config.QpsInitial, config.QpsMin, config.QpsMax),
+		walker.WithThreadsPerNs(config.GetThreadsPerNs()),
+		walker.WithStrategy(config.Strategy),
+		walker.WithTransport(config.Transport, config.EdnsSize),
+		walker.WithQuitAfter(config.QuitAfterMin),
+		walker.WithProgressInterval(config.LogCounterIntervalSec),
+	}
+
+	flags := []struct {
+		isSet  bool
+		option walker.Option
+	}{
+		{len(config.GetNameServers()) > 0, walker.WithNameServers(config.GetNameServers()...)},
+		{config.DomainsFile != "", walker.WithDomainsFile(config.DomainsFile, config.Concurrency)},
+		{config.GetFilePrefix() != "", walker.WithFileOutput(config.GetFilePrefix())},
+		{config.Jsonl != "", walker.WithJsonlOutput(config.Jsonl)},
+		{config.Resume, walker.WithResume()},
+		{config.FillGaps != "", walker.WithFillGaps(config.FillGaps)},
+		{config.IndexDir != "", walker.WithIndexDir(config.IndexDir)},
+		{config.Validate, walker.WithValidation()},
+		{config.QuitOnChange, walker.WithQuitOnChange()},
+		{config.Ipv4Only, walker.WithIpv4Only()},
+		{config.Ipv6Only, walker.WithIpv6Only()},
+		{config.Verbose, walker.WithVerbose()},
+	}
+
+	for _, flag := range flags {
+		if flag.isSet {
+			options = append(options, flag.option)
+		}
+	}
+
+	return
+}
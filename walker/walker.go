@@ -0,0 +1,292 @@
+// Package walker is the embeddable API of nsec3walker, collecting NSEC3 hashes of a DNS zone.
+//
+//	w, err := walker.New("example.com",
+//		walker.WithRateLimit(20, 1, 200),
+//		walker.OnHash(func(hash walker.Hash) { fmt.Println(hash.Hash) }),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	defer w.Close()
+//
+//	err = w.Run(ctx)
+package walker
+
+import (
+	"context"
+
+	nsec3walker "github.com/unsecured-company/nsec3walker/internal"
+)
+
+type (
+	Range            = nsec3walker.RangeEvent
+	Hash             = nsec3walker.HashEvent
+	Name             = nsec3walker.NameEvent
+	Progress         = nsec3walker.ProgressEvent
+	ZoneChange       = nsec3walker.ZoneChange
+	RangeChangeError = nsec3walker.RangeChangeError
+	Sink             = nsec3walker.Sink
+)
+
+const (
+	StrategyGaps       = nsec3walker.StrategyGaps
+	StrategySequential = nsec3walker.StrategySequential
+	TransportAuto      = nsec3walker.TransportAuto
+	TransportTcp       = nsec3walker.TransportTcp
+	TransportUdp       = nsec3walker.TransportUdp
+)
+
+var (
+	ErrBadRcode      = nsec3walker.ErrBadRcode
+	ErrBlackLies     = nsec3walker.ErrBlackLies
+	ErrNoNsec3       = nsec3walker.ErrNoNsec3
+	ErrParamsChanged = nsec3walker.ErrParamsChanged
+	ErrWhiteLies     = nsec3walker.ErrWhiteLies
+)
+
+// Walker walks a zone, or zones from WithDomainsFile.
+type Walker struct {
+	config *nsec3walker.Config
+}
+
+type Option func(w *Walker)
+
+// New returns a walker for the domain. Without file output nothing is written, use the hooks to get the results.
+func New(domain string, options ...Option) (w *Walker, err error) {
+	w = &Walker{
+		config: nsec3walker.NewWalkConfig(domain),
+	}
+
+	w.config.Output.SetSilent(true)
+
+	for _, option := range options {
+		option(w)
+	}
+
+	err = w.config.Prepare()
+
+	if err != nil {
+		w.Close()
+
+		return nil, err
+	}
+
+	return
+}
+
+// Run walks until the chain is complete, there are no new hashes for a while, or the context is cancelled.
+// A failed write into the outputs stops the walk and is returned.
+func (w *Walker) Run(ctx context.Context) (err error) {
+	nw := nsec3walker.NewNSec3Walker(w.config)
+
+	if w.config.Action == nsec3walker.ActionWalkBatch {
+		err = nw.RunWalkBatch(ctx)
+	} else {
+		err = nw.RunWalk(ctx)
+	}
+
+	if err == nil {
+		err = w.config.Output.Err()
+	}
+
+	if err != nil {
+		w.config.Output.Failed(err)
+	}
+
+	return
+}
+
+// Close flushes and closes output files.
+func (w *Walker) Close() {
+	w.config.Output.Close()
+}
+
+// WithNameServers walks the given authoritative NS servers, instead of looking them up.
+func WithNameServers(servers ...string) Option {
+	return func(w *Walker) {
+		w.config.SetNameServers(servers)
+	}
+}
+
+// WithResolvers sets generic DNS resolvers used for looking up NS servers of the domain.
+func WithResolvers(servers ...string) Option {
+	return func(w *Walker) {
+		w.config.SetResolvers(servers)
+	}
+}
+
+// WithRateLimit sets queries per second for each NS server address, the rate adapts between qpsMin and qpsMax.
+func WithRateLimit(qps float64, qpsMin float64, qpsMax float64) Option {
+	return func(w *Walker) {
+		w.config.QpsInitial = qps
+		w.config.QpsMin = qpsMin
+		w.config.QpsMax = qpsMax
+	}
+}
+
+func WithThreadsPerNs(threads int) Option {
+	return func(w *Walker) {
+		w.config.SetThreadsPerNs(threads)
+	}
+}
+
+func WithStrategy(strategy string) Option {
+	return func(w *Walker) {
+		w.config.Strategy = strategy
+	}
+}
+
+func WithTransport(transport string, ednsSize int) Option {
+	return func(w *Walker) {
+		w.config.Transport = transport
+		w.config.EdnsSize = ednsSize
+	}
+}
+
+// WithIpv4Only walks only IPv4 addresses of NS servers.
+func WithIpv4Only() Option {
+	return func(w *Walker) {
+		w.config.Ipv4Only = true
+	}
+}
+
+// WithIpv6Only walks only IPv6 addresses of NS servers.
+func WithIpv6Only() Option {
+	return func(w *Walker) {
+		w.config.Ipv6Only = true
+	}
+}
+
+// WithQuitAfter stops the walk after minutes without new hashes.
+func WithQuitAfter(minutes int) Option {
+	return func(w *Walker) {
+		w.config.QuitAfterMin = minutes
+	}
+}
+
+// WithProgressInterval sets how often counters are logged and OnProgress is called.
+func WithProgressInterval(seconds int) Option {
+	return func(w *Walker) {
+		w.config.LogCounterIntervalSec = seconds
+	}
+}
+
+// WithValidation verifies RRSIGs of NSEC3 records, invalid ones are discarded.
+func WithValidation() Option {
+	return func(w *Walker) {
+		w.config.Validate = true
+	}
+}
+
+// WithQuitOnChange stops the walk when the zone changes, instead of recording the changes.
+func WithQuitOnChange() Option {
+	return func(w *Walker) {
+		w.config.QuitOnChange = true
+	}
+}
+
+// WithDomainsFile walks zones from the file, one domain per line, concurrency of them at once.
+// The domain of New is empty then, and WithFileOutput is a directory getting files of every zone.
+func WithDomainsFile(filePath string, concurrency int) Option {
+	return func(w *Walker) {
+		w.config.DomainsFile = filePath
+		w.config.Concurrency = concurrency
+	}
+}
+
+// WithFileOutput writes prefix.[log,csv,hash,changes] files, the same as the walk command does.
+func WithFileOutput(prefix string) Option {
+	return func(w *Walker) {
+		w.config.SetFilePrefix(prefix)
+	}
+}
+
+// WithJsonlOutput writes ranges, logs and progress as JSON Lines into the file, "-" for stdout.
+func WithJsonlOutput(filePath string) Option {
+	return func(w *Walker) {
+		w.config.Jsonl = filePath
+	}
+}
+
+// WithSink adds an output sink, it gets everything the file outputs get. It is closed by Close.
+func WithSink(sink Sink) Option {
+	return func(w *Walker) {
+		w.config.Output.AddSink(sink)
+	}
+}
+
+// WithResume loads ranges from the CSV file of WithFileOutput, and continues the walk.
+func WithResume() Option {
+	return func(w *Walker) {
+		w.config.Resume = true
+	}
+}
+
+// WithFillGaps queries only the gaps of an incomplete CSV file and appends new ranges to it,
+// the domain of New can be empty, it is taken from the file.
+func WithFillGaps(csvPath string) Option {
+	return func(w *Walker) {
+		w.config.FillGaps = csvPath
+	}
+}
+
+// WithIndexDir keeps range indexes in files in the directory, for zones larger than memory.
+// WithResume continues from them, if the previous walk was closed.
+func WithIndexDir(dir string) Option {
+	return func(w *Walker) {
+		w.config.IndexDir = dir
+	}
+}
+
+// WithStdout prints hashes to stdout when there is no file output, the same as the walk command does.
+func WithStdout() Option {
+	return func(w *Walker) {
+		w.config.Output.SetSilent(false)
+	}
+}
+
+// WithLogger gets log lines instead of the standard log, e.g. to redirect or silence them.
+// Log files and sinks get them either way.
+func WithLogger(logger func(message string)) Option {
+	return func(w *Walker) {
+		w.config.Output.SetLogger(logger)
+	}
+}
+
+func WithVerbose() Option {
+	return func(w *Walker) {
+		w.config.Verbose = true
+	}
+}
+
+func OnRange(hook func(Range)) Option {
+	return func(w *Walker) {
+		w.config.Hooks.OnRange = hook
+	}
+}
+
+func OnHash(hook func(Hash)) Option {
+	return func(w *Walker) {
+		w.config.Hooks.OnHash = hook
+	}
+}
+
+// OnName gets names of a zone signed with plain NSEC, OnRange and OnHash are not called for it.
+func OnName(hook func(Name)) Option {
+	return func(w *Walker) {
+		w.config.Hooks.OnName = hook
+	}
+}
+
+func OnProgress(hook func(Progress)) Option {
+	return func(w *Walker) {
+		w.config.Hooks.OnProgress = hook
+	}
+}
+
+// OnError gets errors the walk continues after, like timeouts of NS servers or changed ranges.
+func OnError(hook func(error)) Option {
+	return func(w *Walker) {
+		w.config.Hooks.OnError = hook
+	}
+}